@@ -0,0 +1,30 @@
+package admin
+
+import (
+	"context"
+
+	"github.com/acoshift/pgsql/pgctx"
+
+	"github.com/acoshift/pikkanode/internal/session"
+)
+
+// checkAdmin returns error if signed in user is not admin
+func checkAdmin(ctx context.Context) error {
+	userID := session.GetUserID(ctx)
+	if userID == "" {
+		return errInvalidCredentials
+	}
+
+	var isAdmin bool
+	// language=SQL
+	err := pgctx.QueryRow(ctx, `
+		select exists(select 1 from users where id = $1 and is_admin)
+	`, userID).Scan(&isAdmin)
+	if err != nil {
+		return err
+	}
+	if !isAdmin {
+		return errForbidden
+	}
+	return nil
+}
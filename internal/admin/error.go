@@ -0,0 +1,10 @@
+package admin
+
+import (
+	"github.com/acoshift/arpc"
+)
+
+var (
+	errInvalidCredentials = arpc.NewError("invalid credentials")
+	errForbidden          = arpc.NewError("forbidden")
+)
@@ -0,0 +1,49 @@
+package admin
+
+import (
+	"context"
+	"time"
+
+	"github.com/acoshift/pikkanode/internal/invite"
+	"github.com/acoshift/pikkanode/internal/validator"
+)
+
+type CreateInvitesRequest struct {
+	Count      int `json:"count"`
+	MaxUses    int `json:"maxUses"`
+	ExpireDays int `json:"expireDays"`
+}
+
+func (req *CreateInvitesRequest) Valid() error {
+	v := validator.New()
+	v.Must(req.Count >= 1 && req.Count <= 1000, "count must between 1 and 1000")
+	v.Must(req.MaxUses >= 1, "max uses must at least 1")
+	v.Must(req.ExpireDays >= 1 && req.ExpireDays <= 365, "expire days must between 1 and 365")
+
+	return v.Error()
+}
+
+type CreateInvitesResult struct {
+	Codes     []string  `json:"codes"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+func CreateInvites(ctx context.Context, req *CreateInvitesRequest) (*CreateInvitesResult, error) {
+	err := checkAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var r CreateInvitesResult
+	r.ExpiresAt = time.Now().AddDate(0, 0, req.ExpireDays)
+	r.Codes = make([]string, 0, req.Count)
+	for i := 0; i < req.Count; i++ {
+		code, err := invite.Create(ctx, "", req.MaxUses, r.ExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+		r.Codes = append(r.Codes, code)
+	}
+
+	return &r, nil
+}
@@ -4,43 +4,77 @@ import (
 	"context"
 	"io/ioutil"
 	"net/http"
-	"regexp"
 	"strings"
 	"unicode/utf8"
 
+	"github.com/acoshift/pgsql/pgctx"
+
+	"github.com/acoshift/pikkanode/internal/config"
+	"github.com/acoshift/pikkanode/internal/invite"
 	"github.com/acoshift/pikkanode/internal/password"
 	"github.com/acoshift/pikkanode/internal/session"
+	"github.com/acoshift/pikkanode/internal/username"
 	"github.com/acoshift/pikkanode/internal/validator"
 )
 
 type SignUpRequest struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username   string `json:"username"`
+	Password   string `json:"password"`
+	InviteCode string `json:"inviteCode"`
 }
 
-var reUsername = regexp.MustCompile(`^[a-zA-Z0-9]*$`)
-
 func (req *SignUpRequest) Valid() error {
 	v := validator.New()
 	v.Must(req.Username != "", "username required")
 	v.Must(utf8.RuneCountInString(req.Username) >= 6, "username minimum 6 characters")
 	v.Must(utf8.RuneCountInString(req.Username) <= 15, "username maximum 15 characters")
-	v.Must(reUsername.MatchString(req.Username), "invalid username")
+	v.Must(username.Valid(req.Username), "invalid username")
+	v.Must(username.Allowed(req.Username), "username not available")
 
 	v.Must(req.Password != "", "password required")
 	v.Must(utf8.RuneCountInString(req.Password) >= 6, "password minimum 6 characters")
 	v.Must(utf8.RuneCountInString(req.Password) <= 500, "password maximum 500 characters")
 
+	v.Must(utf8.RuneCountInString(req.InviteCode) <= 32, "invite code maximum 32 characters")
+
 	return v.Error()
 }
 
 func SignUp(ctx context.Context, req *SignUpRequest) (*struct{}, error) {
+	mode := config.RegistrationMode()
+	if mode == invite.ModeClosed {
+		return nil, errRegistrationClosed
+	}
+
+	held, err := username.Held(ctx, req.Username, "")
+	if err != nil {
+		return nil, err
+	}
+	if held {
+		return nil, errUsernameNotAvailable
+	}
+
 	hashed, err := password.Hash(req.Password)
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = insertUser(ctx, req.Username, hashed)
+	err = pgctx.RunInTx(ctx, func(ctx context.Context) error {
+		var inviteCode string
+		if mode == invite.ModeInvite {
+			inviteCode = strings.ToUpper(req.InviteCode)
+			err := invite.Use(ctx, inviteCode)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err := insertUser(ctx, req.Username, hashed, inviteCode)
+		return err
+	})
+	if err == invite.ErrInvalidCode {
+		return nil, errInvalidInviteCode
+	}
 	if err == errUsernameDuplicated {
 		return nil, errUsernameNotAvailable
 	}
@@ -77,13 +111,24 @@ func SignIn(ctx context.Context, req *SignInRequest) (*struct{}, error) {
 		return nil, errInvalidCredentials
 	}
 
-	session.Get(ctx).Set("user_id", userID)
+	if password.NeedsRehash(hashed) {
+		hashed, err = password.Hash(req.Password)
+		if err != nil {
+			return nil, err
+		}
+		err = setUserPassword(ctx, userID, hashed)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	session.SignIn(ctx, userID)
 
 	return new(struct{}), nil
 }
 
 func SignOut(ctx context.Context, _ *struct{}) (*struct{}, error) {
-	session.Get(ctx).Destroy()
+	session.SignOut(ctx)
 	return new(struct{}), nil
 }
 
@@ -7,4 +7,6 @@ import (
 var (
 	errUsernameNotAvailable = arpc.NewError("username not available")
 	errInvalidCredentials   = arpc.NewError("invalid credentials")
+	errRegistrationClosed   = arpc.NewError("registration closed")
+	errInvalidInviteCode    = arpc.NewError("invalid invite code")
 )
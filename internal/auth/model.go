@@ -14,15 +14,15 @@ var (
 	errNotFound           = errors.New("auth: not found")
 )
 
-func insertUser(ctx context.Context, username, hashedPassword string) (userID string, err error) {
+func insertUser(ctx context.Context, username, hashedPassword, inviteCode string) (userID string, err error) {
 	// language=SQL
 	err = pgctx.QueryRow(ctx, `
 		insert into users
-			(username, password)
+			(username, password, invite_code)
 		values
-			($1, $2)
+			($1, $2, nullif($3, ''))
 		returning id
-	`, username, hashedPassword).Scan(&userID)
+	`, username, hashedPassword, inviteCode).Scan(&userID)
 	if pgsql.IsUniqueViolation(err, "users_username_idx") {
 		return "", errUsernameDuplicated
 	}
@@ -34,10 +34,64 @@ func getUserIDAndPasswordByUsername(ctx context.Context, username string) (userI
 	err = pgctx.QueryRow(ctx, `
 		select id, password
 		from users
-		where username = $1
+		where lower(username) = lower($1)
 	`, username).Scan(&userID, &hashedPassword)
 	if err == sql.ErrNoRows {
 		return "", "", errNotFound
 	}
 	return
 }
+
+// insertUserIfAvailable inserts new user, returns empty user id if username not available
+func insertUserIfAvailable(ctx context.Context, username, hashedPassword, inviteCode string) (userID string, err error) {
+	// language=SQL
+	err = pgctx.QueryRow(ctx, `
+		insert into users
+			(username, password, invite_code)
+		values
+			($1, $2, nullif($3, ''))
+		on conflict do nothing
+		returning id
+	`, username, hashedPassword, inviteCode).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return
+}
+
+func getUserIDByIdentity(ctx context.Context, provider, subject string) (userID string, err error) {
+	// language=SQL
+	err = pgctx.QueryRow(ctx, `
+		select user_id
+		from user_identities
+		where provider = $1 and subject = $2
+	`, provider, subject).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return "", errNotFound
+	}
+	return
+}
+
+func insertIdentity(ctx context.Context, userID, provider, subject, email string) error {
+	// language=SQL
+	_, err := pgctx.Exec(ctx, `
+		insert into user_identities
+			(user_id, provider, subject, email)
+		values
+			($1, $2, $3, $4)
+	`, userID, provider, subject, email)
+	if pgsql.IsUniqueViolation(err, "user_identities_pkey") {
+		return errOIDCIdentityLinked
+	}
+	return err
+}
+
+func setUserPassword(ctx context.Context, userID, hashedPassword string) error {
+	// language=SQL
+	_, err := pgctx.Exec(ctx, `
+		update users
+		set password = $2
+		where id = $1
+	`, userID, hashedPassword)
+	return err
+}
@@ -0,0 +1,740 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/acoshift/pgsql/pgctx"
+
+	"github.com/acoshift/pikkanode/internal/config"
+	"github.com/acoshift/pikkanode/internal/invite"
+	"github.com/acoshift/pikkanode/internal/session"
+	"github.com/acoshift/pikkanode/internal/username"
+)
+
+// OIDC providers are configured by name, ex.
+//
+//	oidc_providers: google,gitlab
+//	oidc_google_issuer: https://accounts.google.com
+//	oidc_google_client_id: xxx
+//	oidc_google_client_secret: xxx
+var (
+	oidcProviders   = loadOIDCProviders()
+	oidcCallbackURL = config.BaseURL() + "/auth/oidc/callback"
+	oidcRedirectURL = config.String("oidc_redirect_url")
+	oidcClient      = &http.Client{Timeout: 10 * time.Second}
+)
+
+// oidcKeysRefreshInterval is the minimum duration between fetching provider's keys,
+// keys are refetched when id token signed by unknown key
+const oidcKeysRefreshInterval = time.Minute
+
+var (
+	errOIDCProviderNotFound = errors.New("auth: oidc provider not found")
+	errOIDCInvalidState     = errors.New("auth: oidc invalid state")
+	errOIDCInvalidToken     = errors.New("auth: oidc invalid id token")
+	errOIDCIdentityLinked   = errors.New("auth: oidc identity linked to another user")
+)
+
+type oidcProvider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+
+	mu            sync.Mutex
+	discovery     *oidcDiscovery
+	keys          map[string]crypto.PublicKey
+	keysFetchedAt time.Time
+}
+
+type oidcDiscovery struct {
+	Issuer                   string   `json:"issuer"`
+	AuthorizationEndpoint    string   `json:"authorization_endpoint"`
+	TokenEndpoint            string   `json:"token_endpoint"`
+	UserinfoEndpoint         string   `json:"userinfo_endpoint"`
+	JWKSURI                  string   `json:"jwks_uri"`
+	TokenEndpointAuthMethods []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+func loadOIDCProviders() map[string]*oidcProvider {
+	m := make(map[string]*oidcProvider)
+	for _, name := range strings.Split(config.String("oidc_providers"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		m[name] = &oidcProvider{
+			Name:         name,
+			Issuer:       strings.TrimSuffix(config.String("oidc_"+name+"_issuer"), "/"),
+			ClientID:     config.String("oidc_" + name + "_client_id"),
+			ClientSecret: config.String("oidc_" + name + "_client_secret"),
+		}
+	}
+	return m
+}
+
+func (p *oidcProvider) getJSON(ctx context.Context, endpoint, accessToken string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+
+	resp, err := oidcClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("auth: oidc %s get %s status %d", p.Name, endpoint, resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// discover fetches provider metadata, the result is cached after first success
+func (p *oidcProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	discovery := p.discovery
+	p.mu.Unlock()
+
+	if discovery != nil {
+		return discovery, nil
+	}
+
+	// fetch without lock, concurrent first requests may fetch more than once
+	var d oidcDiscovery
+	err := p.getJSON(ctx, p.Issuer+"/.well-known/openid-configuration", "", &d)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(d.Issuer, "/") != p.Issuer {
+		return nil, fmt.Errorf("auth: oidc discovery %s issuer mismatch", p.Name)
+	}
+	if d.JWKSURI == "" {
+		return nil, fmt.Errorf("auth: oidc discovery %s jwks_uri missing", p.Name)
+	}
+
+	p.mu.Lock()
+	p.discovery = &d
+	p.mu.Unlock()
+
+	return &d, nil
+}
+
+type oidcJWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k *oidcJWK) publicKey() (crypto.PublicKey, error) {
+	decode := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("auth: oidc unsupported curve %s", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("auth: oidc unsupported key type %s", k.Kty)
+	}
+}
+
+// key returns provider's signing key by key id,
+// keys are refetched when not found, at most once per refresh interval
+func (p *oidcProvider) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	k, ok := p.keys[kid]
+	refresh := !ok && time.Since(p.keysFetchedAt) >= oidcKeysRefreshInterval
+	if refresh {
+		// reserve refresh, other requests do not refetch while fetching
+		p.keysFetchedAt = time.Now()
+	}
+	p.mu.Unlock()
+
+	if ok {
+		return k, nil
+	}
+	if !refresh {
+		return nil, errOIDCInvalidToken
+	}
+
+	// fetch without lock, slow provider must not block other sign in
+	var set struct {
+		Keys []*oidcJWK `json:"keys"`
+	}
+	err = p.getJSON(ctx, d.JWKSURI, "", &set)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, x := range set.Keys {
+		if x.Use != "" && x.Use != "sig" {
+			continue
+		}
+		k, err := x.publicKey()
+		if err != nil {
+			// skip key we do not support
+			continue
+		}
+		keys[x.Kid] = k
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+
+	if k, ok := keys[kid]; ok {
+		return k, nil
+	}
+	return nil, errOIDCInvalidToken
+}
+
+type oidcTokenResult struct {
+	AccessToken string `json:"access_token"`
+	IDToken     string `json:"id_token"`
+}
+
+func (p *oidcProvider) exchange(ctx context.Context, code, verifier string) (*oidcTokenResult, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", oidcCallbackURL)
+	form.Set("code_verifier", verifier)
+
+	// client_secret_basic is the default when provider not specify
+	basicAuth := len(d.TokenEndpointAuthMethods) == 0
+	for _, m := range d.TokenEndpointAuthMethods {
+		if m == "client_secret_basic" {
+			basicAuth = true
+		}
+	}
+	if !basicAuth {
+		form.Set("client_id", p.ClientID)
+		form.Set("client_secret", p.ClientSecret)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if basicAuth {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	resp, err := oidcClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("auth: oidc token %s status %d", p.Name, resp.StatusCode)
+	}
+
+	var r oidcTokenResult
+	err = json.NewDecoder(resp.Body).Decode(&r)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+type oidcAudience []string
+
+func (aud *oidcAudience) UnmarshalJSON(b []byte) error {
+	var s string
+	if json.Unmarshal(b, &s) == nil {
+		*aud = oidcAudience{s}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(aud))
+}
+
+type oidcClaims struct {
+	Issuer            string       `json:"iss"`
+	Subject           string       `json:"sub"`
+	Audience          oidcAudience `json:"aud"`
+	Expiry            int64        `json:"exp"`
+	Nonce             string       `json:"nonce"`
+	Email             string       `json:"email"`
+	PreferredUsername string       `json:"preferred_username"`
+	Name              string       `json:"name"`
+}
+
+// verifyIDToken verifies id token signature with provider's keys,
+// then validates its claims
+func (p *oidcProvider) verifyIDToken(ctx context.Context, idToken, nonce string) (*oidcClaims, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, errOIDCInvalidToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	{
+		b, err := base64.RawURLEncoding.DecodeString(parts[0])
+		if err != nil {
+			return nil, errOIDCInvalidToken
+		}
+		err = json.Unmarshal(b, &header)
+		if err != nil {
+			return nil, errOIDCInvalidToken
+		}
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errOIDCInvalidToken
+	}
+
+	key, err := p.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	hashed := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if header.Alg != "RS256" || rsa.VerifyPKCS1v15(key, crypto.SHA256, hashed[:], sig) != nil {
+			return nil, errOIDCInvalidToken
+		}
+	case *ecdsa.PublicKey:
+		if header.Alg != "ES256" || len(sig) != 64 {
+			return nil, errOIDCInvalidToken
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(key, hashed[:], r, s) {
+			return nil, errOIDCInvalidToken
+		}
+	default:
+		return nil, errOIDCInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errOIDCInvalidToken
+	}
+
+	var c oidcClaims
+	err = json.Unmarshal(payload, &c)
+	if err != nil {
+		return nil, errOIDCInvalidToken
+	}
+
+	if strings.TrimSuffix(c.Issuer, "/") != p.Issuer {
+		return nil, errOIDCInvalidToken
+	}
+	validAud := false
+	for _, aud := range c.Audience {
+		if aud == p.ClientID {
+			validAud = true
+		}
+	}
+	if !validAud {
+		return nil, errOIDCInvalidToken
+	}
+	if time.Now().Unix() >= c.Expiry {
+		return nil, errOIDCInvalidToken
+	}
+	if subtle.ConstantTimeCompare([]byte(c.Nonce), []byte(nonce)) != 1 {
+		return nil, errOIDCInvalidToken
+	}
+	if c.Subject == "" {
+		return nil, errOIDCInvalidToken
+	}
+
+	return &c, nil
+}
+
+// userinfo fills profile claims from provider's userinfo endpoint,
+// id token claims are kept when provider does not have userinfo endpoint
+func (p *oidcProvider) userinfo(ctx context.Context, accessToken string, c *oidcClaims) error {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return err
+	}
+	if d.UserinfoEndpoint == "" || accessToken == "" {
+		return nil
+	}
+
+	var u struct {
+		Subject           string `json:"sub"`
+		Email             string `json:"email"`
+		PreferredUsername string `json:"preferred_username"`
+		Name              string `json:"name"`
+	}
+	err = p.getJSON(ctx, d.UserinfoEndpoint, accessToken, &u)
+	if err != nil {
+		return err
+	}
+
+	// userinfo must be the same user as id token (OIDC Core 5.3.2)
+	if u.Subject != c.Subject {
+		return errOIDCInvalidToken
+	}
+
+	c.Email = u.Email
+	c.PreferredUsername = u.PreferredUsername
+	c.Name = u.Name
+	return nil
+}
+
+// oidcAuth is an authorization request,
+// kept in session until provider redirects back to callback
+type oidcAuth struct {
+	State    string
+	Nonce    string
+	Verifier string
+}
+
+func newOIDCAuth() *oidcAuth {
+	return &oidcAuth{
+		State:    generateOIDCRandom(),
+		Nonce:    generateOIDCRandom(),
+		Verifier: generateOIDCRandom(),
+	}
+}
+
+// authCodeURL returns provider's authorization url for the request
+func (p *oidcProvider) authCodeURL(ctx context.Context, a *oidcAuth) (string, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(a.Verifier))
+
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", p.ClientID)
+	q.Set("redirect_uri", oidcCallbackURL)
+	q.Set("scope", "openid profile email")
+	q.Set("state", a.State)
+	q.Set("nonce", a.Nonce)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	q.Set("code_challenge_method", "S256")
+
+	return d.AuthorizationEndpoint + "?" + q.Encode(), nil
+}
+
+// callback verifies callback parameters of the request,
+// exchanges code for tokens, then returns verified identity claims
+func (p *oidcProvider) callback(ctx context.Context, a *oidcAuth, form url.Values) (*oidcClaims, error) {
+	if e := form.Get("error"); e != "" {
+		return nil, errors.New(e)
+	}
+
+	if a.State == "" || subtle.ConstantTimeCompare([]byte(form.Get("state")), []byte(a.State)) != 1 {
+		return nil, errOIDCInvalidState
+	}
+
+	tk, err := p.exchange(ctx, form.Get("code"), a.Verifier)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := p.verifyIDToken(ctx, tk.IDToken, a.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.userinfo(ctx, tk.AccessToken, claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+func OIDCLoginHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		p := oidcProviders[r.FormValue("provider")]
+		if p == nil {
+			oidcRedirectError(w, r, errOIDCProviderNotFound)
+			return
+		}
+
+		a := newOIDCAuth()
+		u, err := p.authCodeURL(ctx, a)
+		if err != nil {
+			oidcRedirectError(w, r, err)
+			return
+		}
+
+		s := session.Get(ctx)
+		s.Set("oidc_provider", p.Name)
+		s.Set("oidc_state", a.State)
+		s.Set("oidc_nonce", a.Nonce)
+		s.Set("oidc_verifier", a.Verifier)
+		s.Set("oidc_invite", strings.ToUpper(r.FormValue("invite")))
+
+		http.Redirect(w, r, u, http.StatusFound)
+	})
+}
+
+func OIDCCallbackHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		s := session.Get(ctx)
+		providerName := s.GetString("oidc_provider")
+		a := oidcAuth{
+			State:    s.GetString("oidc_state"),
+			Nonce:    s.GetString("oidc_nonce"),
+			Verifier: s.GetString("oidc_verifier"),
+		}
+		inviteCode := s.GetString("oidc_invite")
+		s.Del("oidc_provider")
+		s.Del("oidc_state")
+		s.Del("oidc_nonce")
+		s.Del("oidc_verifier")
+		s.Del("oidc_invite")
+
+		p := oidcProviders[providerName]
+		if p == nil {
+			oidcRedirectError(w, r, errOIDCProviderNotFound)
+			return
+		}
+
+		r.ParseForm()
+		claims, err := p.callback(ctx, &a, r.Form)
+		if err != nil {
+			oidcRedirectError(w, r, err)
+			return
+		}
+
+		currentUserID := session.GetUserID(ctx)
+		userID, err := signInIdentity(ctx, p.Name, claims, currentUserID, config.RegistrationMode(), inviteCode)
+		if err != nil {
+			oidcRedirectError(w, r, err)
+			return
+		}
+
+		if currentUserID == "" {
+			session.SignIn(ctx, userID)
+		}
+
+		http.Redirect(w, r, oidcRedirectURL, http.StatusFound)
+	})
+}
+
+func oidcRedirectError(w http.ResponseWriter, r *http.Request, err error) {
+	var msg string
+	switch err {
+	case errOIDCProviderNotFound:
+		msg = "provider not found"
+	case errOIDCIdentityLinked:
+		msg = "identity linked to another account"
+	case errRegistrationClosed:
+		msg = "registration closed"
+	case invite.ErrInvalidCode:
+		msg = "invalid invite code"
+	default:
+		msg = "sign in failed"
+	}
+
+	q := url.Values{}
+	q.Set("error", msg)
+	http.Redirect(w, r, oidcRedirectURL+"?"+q.Encode(), http.StatusFound)
+}
+
+// signInIdentity returns user id for the identity,
+// link the identity to current user if signed in,
+// or provision a new user by registration mode when the identity never seen before
+func signInIdentity(ctx context.Context, provider string, c *oidcClaims, currentUserID, mode, inviteCode string) (userID string, err error) {
+	userID, err = getUserIDByIdentity(ctx, provider, c.Subject)
+	if err == nil {
+		if currentUserID != "" && currentUserID != userID {
+			return "", errOIDCIdentityLinked
+		}
+		return userID, nil
+	}
+	if err != errNotFound {
+		return "", err
+	}
+
+	if currentUserID != "" {
+		err = insertIdentity(ctx, currentUserID, provider, c.Subject, c.Email)
+		if err != nil {
+			return "", err
+		}
+		return currentUserID, nil
+	}
+
+	if mode == invite.ModeClosed {
+		return "", errRegistrationClosed
+	}
+	if mode != invite.ModeInvite {
+		inviteCode = ""
+	}
+
+	err = pgctx.RunInTx(ctx, func(ctx context.Context) error {
+		if mode == invite.ModeInvite {
+			err := invite.Use(ctx, inviteCode)
+			if err != nil {
+				return err
+			}
+		}
+
+		base := oidcUsernameBase(c)
+		for i := 0; userID == "" && i < 10; i++ {
+			name := base
+			if i > 0 || !username.Valid(name) || !username.Allowed(name) {
+				name = provisionUsername(base)
+			}
+			// digits fold to look-alike letters, ex. shi + 7
+			if !username.Allowed(name) {
+				continue
+			}
+
+			held, err := username.Held(ctx, name, "")
+			if err != nil {
+				return err
+			}
+			if held {
+				continue
+			}
+
+			// social account do not have password
+			userID, err = insertUserIfAvailable(ctx, name, "", inviteCode)
+			if err != nil {
+				return err
+			}
+		}
+		if userID == "" {
+			return errUsernameDuplicated
+		}
+
+		return insertIdentity(ctx, userID, provider, c.Subject, c.Email)
+	})
+	if err != nil {
+		return "", err
+	}
+	return userID, nil
+}
+
+func oidcUsernameBase(c *oidcClaims) string {
+	candidates := []string{
+		c.PreferredUsername,
+		strings.SplitN(c.Email, "@", 2)[0],
+		c.Name,
+	}
+	for _, x := range candidates {
+		x = sanitizeUsername(x)
+		if x != "" && username.Allowed(x) {
+			return x
+		}
+	}
+	return "user"
+}
+
+// sanitizeUsername removes characters not allowed in username,
+// and truncates to username maximum length
+func sanitizeUsername(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		if b.Len() >= username.MaxLength {
+			break
+		}
+		if username.ValidChar(c) {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// provisionUsername appends random digits to base,
+// result always satisfies username length rule
+func provisionUsername(base string) string {
+	if len(base) > 10 {
+		base = base[:10]
+	}
+
+	var b strings.Builder
+	b.WriteString(base)
+	for b.Len() < username.MinLength || b.Len() < len(base)+5 {
+		n, _ := rand.Int(rand.Reader, big.NewInt(10))
+		b.WriteString(n.String())
+	}
+	return b.String()
+}
+
+func generateOIDCRandom() string {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,614 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/acoshift/pgsql/pgctx"
+
+	"github.com/acoshift/pikkanode/internal/invite"
+	"github.com/acoshift/pikkanode/internal/testdb"
+	"github.com/acoshift/pikkanode/internal/username"
+)
+
+func TestMain(m *testing.M) {
+	testdb.Main(m)
+}
+
+const (
+	stubClientID     = "client"
+	stubClientSecret = "secret"
+	stubKeyID        = "key1"
+)
+
+type stubUser struct {
+	Subject           string
+	Email             string
+	PreferredUsername string
+	Name              string
+}
+
+type stubAuthorization struct {
+	Challenge string
+	Nonce     string
+}
+
+// stubProvider is a local OIDC provider,
+// serves discovery, authorize, token, userinfo and jwks endpoints
+type stubProvider struct {
+	*httptest.Server
+
+	key *rsa.PrivateKey
+
+	mu     sync.Mutex
+	codes  map[string]*stubAuthorization
+	tokens map[string]*stubUser
+
+	// User is the user signing in at authorize endpoint
+	User stubUser
+
+	// SignKey overrides key used to sign id token
+	SignKey *rsa.PrivateKey
+
+	// Claims modifies id token claims before signing
+	Claims func(claims map[string]interface{})
+
+	// UserinfoSubject overrides subject returned from userinfo endpoint
+	UserinfoSubject string
+}
+
+func newStubProvider(t *testing.T) *stubProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &stubProvider{
+		key:    key,
+		codes:  make(map[string]*stubAuthorization),
+		tokens: make(map[string]*stubUser),
+		User: stubUser{
+			Subject:           generateOIDCRandom(),
+			Email:             "tester@example.com",
+			PreferredUsername: "tester",
+			Name:              "Tester",
+		},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", s.discovery)
+	mux.HandleFunc("/authorize", s.authorize)
+	mux.HandleFunc("/token", s.token)
+	mux.HandleFunc("/userinfo", s.userinfo)
+	mux.HandleFunc("/jwks", s.jwks)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+func (s *stubProvider) provider() *oidcProvider {
+	return &oidcProvider{
+		Name:         "stub",
+		Issuer:       s.URL,
+		ClientID:     stubClientID,
+		ClientSecret: stubClientSecret,
+	}
+}
+
+func (s *stubProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"issuer":                                s.URL,
+		"authorization_endpoint":                s.URL + "/authorize",
+		"token_endpoint":                        s.URL + "/token",
+		"userinfo_endpoint":                     s.URL + "/userinfo",
+		"jwks_uri":                              s.URL + "/jwks",
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic"},
+	})
+}
+
+func (s *stubProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("response_type") != "code" || q.Get("client_id") != stubClientID || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	code := generateOIDCRandom()
+	s.mu.Lock()
+	s.codes[code] = &stubAuthorization{
+		Challenge: q.Get("code_challenge"),
+		Nonce:     q.Get("nonce"),
+	}
+	s.mu.Unlock()
+
+	rq := url.Values{}
+	rq.Set("code", code)
+	rq.Set("state", q.Get("state"))
+	http.Redirect(w, r, q.Get("redirect_uri")+"?"+rq.Encode(), http.StatusFound)
+}
+
+func (s *stubProvider) token(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, _ := r.BasicAuth()
+	if clientID != stubClientID || clientSecret != stubClientSecret {
+		http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+		return
+	}
+	if r.FormValue("grant_type") != "authorization_code" {
+		http.Error(w, `{"error":"unsupported_grant_type"}`, http.StatusBadRequest)
+		return
+	}
+
+	// code can be used only once
+	s.mu.Lock()
+	a := s.codes[r.FormValue("code")]
+	delete(s.codes, r.FormValue("code"))
+	s.mu.Unlock()
+	if a == nil {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+
+	challenge := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(challenge[:]) != a.Challenge {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+
+	claims := map[string]interface{}{
+		"iss":   s.URL,
+		"sub":   s.User.Subject,
+		"aud":   stubClientID,
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"iat":   time.Now().Unix(),
+		"nonce": a.Nonce,
+	}
+	if s.Claims != nil {
+		s.Claims(claims)
+	}
+
+	accessToken := generateOIDCRandom()
+	user := s.User
+	s.mu.Lock()
+	s.tokens[accessToken] = &user
+	s.mu.Unlock()
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"id_token":     s.sign(claims),
+	})
+}
+
+func (s *stubProvider) sign(claims map[string]interface{}) string {
+	key := s.key
+	if s.SignKey != nil {
+		key = s.SignKey
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": stubKeyID, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	hashed := sha256.Sum256([]byte(input))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		panic(err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func (s *stubProvider) userinfo(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	u := s.tokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+	s.mu.Unlock()
+	if u == nil {
+		http.Error(w, `{"error":"invalid_token"}`, http.StatusUnauthorized)
+		return
+	}
+
+	sub := u.Subject
+	if s.UserinfoSubject != "" {
+		sub = s.UserinfoSubject
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"sub":                sub,
+		"email":              u.Email,
+		"preferred_username": u.PreferredUsername,
+		"name":               u.Name,
+	})
+}
+
+func (s *stubProvider) jwks(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": []map[string]string{
+			{
+				"kty": "RSA",
+				"kid": stubKeyID,
+				"use": "sig",
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(s.key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(s.key.E)).Bytes()),
+			},
+		},
+	})
+}
+
+// authorizeCallback sends user to authorize endpoint,
+// returns parameters provider sent back to callback
+func authorizeCallback(t *testing.T, p *oidcProvider, a *oidcAuth) url.Values {
+	t.Helper()
+
+	u, err := p.authCodeURL(context.Background(), a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	loc, err := resp.Location()
+	if err != nil {
+		t.Fatalf("authorize: expected redirect; got status %d", resp.StatusCode)
+	}
+	return loc.Query()
+}
+
+func TestOIDCCallback(t *testing.T) {
+	s := newStubProvider(t)
+	defer s.Close()
+	p := s.provider()
+
+	a := newOIDCAuth()
+	form := authorizeCallback(t, p, a)
+
+	claims, err := p.callback(context.Background(), a, form)
+	if err != nil {
+		t.Fatalf("callback: %v", err)
+	}
+	if claims.Subject != s.User.Subject {
+		t.Errorf("subject: expected %q; got %q", s.User.Subject, claims.Subject)
+	}
+	// profile claims come from userinfo, not included in id token
+	if claims.Email != s.User.Email {
+		t.Errorf("email: expected %q; got %q", s.User.Email, claims.Email)
+	}
+	if claims.PreferredUsername != s.User.PreferredUsername {
+		t.Errorf("preferred username: expected %q; got %q", s.User.PreferredUsername, claims.PreferredUsername)
+	}
+
+	// code can not be reused
+	_, err = p.callback(context.Background(), a, form)
+	if err == nil {
+		t.Error("reused code: expected error")
+	}
+}
+
+func TestOIDCCallbackState(t *testing.T) {
+	s := newStubProvider(t)
+	defer s.Close()
+	p := s.provider()
+
+	cases := []struct {
+		Name  string
+		State string
+		Form  string
+	}{
+		{"mismatch", "session-state", "other-state"},
+		{"missing in callback", "session-state", ""},
+		{"missing in session", "", ""},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			a := newOIDCAuth()
+			form := authorizeCallback(t, p, a)
+
+			a.State = c.State
+			form.Set("state", c.Form)
+			_, err := p.callback(context.Background(), a, form)
+			if err != errOIDCInvalidState {
+				t.Errorf("expected %v; got %v", errOIDCInvalidState, err)
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackProviderError(t *testing.T) {
+	s := newStubProvider(t)
+	defer s.Close()
+	p := s.provider()
+
+	a := newOIDCAuth()
+	form := url.Values{}
+	form.Set("error", "access_denied")
+	form.Set("state", a.State)
+
+	_, err := p.callback(context.Background(), a, form)
+	if err == nil || err.Error() != "access_denied" {
+		t.Errorf("expected access_denied; got %v", err)
+	}
+}
+
+func TestOIDCCallbackNonce(t *testing.T) {
+	s := newStubProvider(t)
+	defer s.Close()
+	p := s.provider()
+
+	a := newOIDCAuth()
+	form := authorizeCallback(t, p, a)
+
+	// id token issued for other authorization request
+	a.Nonce = generateOIDCRandom()
+	_, err := p.callback(context.Background(), a, form)
+	if err != errOIDCInvalidToken {
+		t.Errorf("expected %v; got %v", errOIDCInvalidToken, err)
+	}
+}
+
+func TestOIDCCallbackPKCE(t *testing.T) {
+	s := newStubProvider(t)
+	defer s.Close()
+	p := s.provider()
+
+	t.Run("verifier round-trip", func(t *testing.T) {
+		a := newOIDCAuth()
+		form := authorizeCallback(t, p, a)
+
+		_, err := p.callback(context.Background(), a, form)
+		if err != nil {
+			t.Errorf("expected no error; got %v", err)
+		}
+	})
+
+	t.Run("verifier mismatch", func(t *testing.T) {
+		a := newOIDCAuth()
+		form := authorizeCallback(t, p, a)
+
+		a.Verifier = generateOIDCRandom()
+		_, err := p.callback(context.Background(), a, form)
+		if err == nil {
+			t.Error("expected error")
+		}
+	})
+}
+
+func TestOIDCIDTokenValidation(t *testing.T) {
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		Name  string
+		Setup func(s *stubProvider)
+	}{
+		{"signed by other key", func(s *stubProvider) {
+			s.SignKey = otherKey
+		}},
+		{"wrong issuer", func(s *stubProvider) {
+			s.Claims = func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" }
+		}},
+		{"wrong audience", func(s *stubProvider) {
+			s.Claims = func(c map[string]interface{}) { c["aud"] = []string{"other-client"} }
+		}},
+		{"expired", func(s *stubProvider) {
+			s.Claims = func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Minute).Unix() }
+		}},
+		{"missing subject", func(s *stubProvider) {
+			s.Claims = func(c map[string]interface{}) { delete(c, "sub") }
+		}},
+		{"userinfo subject mismatch", func(s *stubProvider) {
+			s.UserinfoSubject = generateOIDCRandom()
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			s := newStubProvider(t)
+			defer s.Close()
+			c.Setup(s)
+			p := s.provider()
+
+			a := newOIDCAuth()
+			form := authorizeCallback(t, p, a)
+
+			_, err := p.callback(context.Background(), a, form)
+			if err != errOIDCInvalidToken {
+				t.Errorf("expected %v; got %v", errOIDCInvalidToken, err)
+			}
+		})
+	}
+}
+
+func TestOIDCIDTokenUnsigned(t *testing.T) {
+	s := newStubProvider(t)
+	defer s.Close()
+	p := s.provider()
+
+	header, _ := json.Marshal(map[string]string{"alg": "none", "kid": stubKeyID})
+	payload, _ := json.Marshal(map[string]interface{}{
+		"iss":   s.URL,
+		"sub":   s.User.Subject,
+		"aud":   stubClientID,
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": "nonce",
+	})
+	token := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload) + "."
+
+	_, err := p.verifyIDToken(context.Background(), token, "nonce")
+	if err != errOIDCInvalidToken {
+		t.Errorf("expected %v; got %v", errOIDCInvalidToken, err)
+	}
+}
+
+func getUsername(ctx context.Context, t *testing.T, userID string) (name string) {
+	t.Helper()
+
+	// language=SQL
+	err := pgctx.QueryRow(ctx, `select username from users where id = $1`, userID).Scan(&name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestSignInIdentityProvisionUsername(t *testing.T) {
+	ctx := testdb.Context(t)
+
+	takenID, taken := testdb.CreateUser(ctx, t)
+
+	held := "h" + taken[1:]
+	testdb.Exec(ctx, t, `insert into username_history (username, user_id) values ($1, $2)`, held, takenID)
+
+	cases := []struct {
+		Name     string
+		Claims   oidcClaims
+		NotEqual string
+	}{
+		{"taken by other user", oidcClaims{PreferredUsername: taken}, taken},
+		{"taken case-insensitive", oidcClaims{PreferredUsername: "U" + taken[1:]}, taken},
+		{"held by username history", oidcClaims{PreferredUsername: held}, held},
+		{"too short", oidcClaims{PreferredUsername: "ab"}, "ab"},
+		{"invalid characters", oidcClaims{Email: "a.b-c@example.com"}, "a.b-c"},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			c.Claims.Subject = generateOIDCRandom()
+
+			userID, err := signInIdentity(ctx, "stub", &c.Claims, "", invite.ModeOpen, "")
+			if err != nil {
+				t.Fatalf("expected no error; got %v", err)
+			}
+			if userID == takenID {
+				t.Fatal("expected new user")
+			}
+
+			name := getUsername(ctx, t, userID)
+			if name == c.NotEqual || !username.Valid(name) {
+				t.Errorf("expected valid username other than %q; got %q", c.NotEqual, name)
+			}
+
+			// sign in again with the same identity
+			again, err := signInIdentity(ctx, "stub", &c.Claims, "", invite.ModeOpen, "")
+			if err != nil {
+				t.Fatalf("sign in again: expected no error; got %v", err)
+			}
+			if again != userID {
+				t.Errorf("sign in again: expected user %s; got %s", userID, again)
+			}
+		})
+	}
+}
+
+func TestSignInIdentityRegistrationMode(t *testing.T) {
+	ctx := testdb.Context(t)
+
+	code, err := invite.Create(ctx, "", 1, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newClaims := func() *oidcClaims {
+		return &oidcClaims{Subject: generateOIDCRandom(), PreferredUsername: "tester"}
+	}
+
+	cases := []struct {
+		Name  string
+		Mode  string
+		Code  string
+		Error error
+	}{
+		{"closed", invite.ModeClosed, "", errRegistrationClosed},
+		{"invite without code", invite.ModeInvite, "", invite.ErrInvalidCode},
+		{"invite with invalid code", invite.ModeInvite, "INVALID", invite.ErrInvalidCode},
+		{"invite with code", invite.ModeInvite, code, nil},
+		{"invite with used code", invite.ModeInvite, code, invite.ErrInvalidCode},
+		{"open ignores code", invite.ModeOpen, "INVALID", nil},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			claims := newClaims()
+			userID, err := signInIdentity(ctx, "stub", claims, "", c.Mode, c.Code)
+			if err != c.Error {
+				t.Fatalf("expected %v; got %v", c.Error, err)
+			}
+			if err != nil {
+				// nothing created
+				_, err = getUserIDByIdentity(ctx, "stub", claims.Subject)
+				if err != errNotFound {
+					t.Errorf("expected identity not created; got %v", err)
+				}
+				return
+			}
+			if userID == "" {
+				t.Error("expected user id")
+			}
+		})
+	}
+
+	// existing identity can sign in when registration closed
+	claims := newClaims()
+	userID, err := signInIdentity(ctx, "stub", claims, "", invite.ModeOpen, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := signInIdentity(ctx, "stub", claims, "", invite.ModeClosed, "")
+	if err != nil || again != userID {
+		t.Errorf("existing identity: expected user %s; got %s, %v", userID, again, err)
+	}
+}
+
+func TestSignInIdentityLink(t *testing.T) {
+	ctx := testdb.Context(t)
+
+	userID, _ := testdb.CreateUser(ctx, t)
+	otherID, _ := testdb.CreateUser(ctx, t)
+	claims := &oidcClaims{Subject: generateOIDCRandom(), Email: "linked@example.com"}
+
+	// link to signed in user, registration mode does not matter
+	linkedID, err := signInIdentity(ctx, "stub", claims, userID, invite.ModeClosed, "")
+	if err != nil {
+		t.Fatalf("link: expected no error; got %v", err)
+	}
+	if linkedID != userID {
+		t.Fatalf("link: expected user %s; got %s", userID, linkedID)
+	}
+
+	// signed out, identity signs in to linked user
+	signedInID, err := signInIdentity(ctx, "stub", claims, "", invite.ModeOpen, "")
+	if err != nil || signedInID != userID {
+		t.Errorf("sign in: expected user %s; got %s, %v", userID, signedInID, err)
+	}
+
+	// linking again by the same user is no-op
+	linkedID, err = signInIdentity(ctx, "stub", claims, userID, invite.ModeOpen, "")
+	if err != nil || linkedID != userID {
+		t.Errorf("link again: expected user %s; got %s, %v", userID, linkedID, err)
+	}
+
+	// identity can not be linked to other user
+	_, err = signInIdentity(ctx, "stub", claims, otherID, invite.ModeOpen, "")
+	if err != errOIDCIdentityLinked {
+		t.Errorf("link other: expected %v; got %v", errOIDCIdentityLinked, err)
+	}
+}
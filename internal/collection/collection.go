@@ -0,0 +1,138 @@
+package collection
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/acoshift/pgsql/pgctx"
+	"github.com/asaskevich/govalidator"
+	"github.com/lib/pq"
+
+	"github.com/acoshift/pikkanode/internal/discovery"
+	"github.com/acoshift/pikkanode/internal/file"
+	"github.com/acoshift/pikkanode/internal/paginate"
+	"github.com/acoshift/pikkanode/internal/session"
+	"github.com/acoshift/pikkanode/internal/validator"
+)
+
+type GetRequest struct {
+	ID       string            `json:"id"`
+	Paginate paginate.Paginate `json:"paginate"`
+}
+
+func (req *GetRequest) Valid() error {
+	v := validator.New()
+	v.Must(req.ID != "", "id required")
+	v.Must(govalidator.IsNumeric(req.ID), "invalid id")
+
+	return v.Error()
+}
+
+type GetResult struct {
+	ID          string                `json:"id"`
+	Name        string                `json:"name"`
+	Description string                `json:"description"`
+	Cover       file.DownloadURL      `json:"cover"`
+	Visibility  string                `json:"visibility"`
+	Username    string                `json:"username"`
+	CreatedAt   time.Time             `json:"createdAt"`
+	List        []*discovery.WorkItem `json:"list"`
+	Paginate    paginate.Paginate     `json:"paginate"`
+}
+
+// Get gets collection with its works in collection order,
+// works hidden from viewer are skipped
+func Get(ctx context.Context, req *GetRequest) (*GetResult, error) {
+	userID := session.GetUserID(ctx)
+
+	var r GetResult
+	{
+		// collection visibility follows the same rules as work visibility
+		// language=SQL
+		err := pgctx.QueryRow(ctx, `
+			select
+				c.id, c.name, c.description, collection_cover(c, nullif($2, '')::uuid), c.visibility,
+				u.username,
+				c.created_at
+			from collections c
+				left join users u on c.user_id = u.id
+			where c.id = $1
+			  and can_view_work(c.user_id, c.visibility, 'published', nullif($2, '')::uuid)
+		`, req.ID, userID).Scan(
+			&r.ID, &r.Name, &r.Description, &r.Cover, &r.Visibility,
+			&r.Username,
+			&r.CreatedAt,
+		)
+		if err == sql.ErrNoRows {
+			return nil, errCollectionNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	{
+		err := req.Paginate.CountFrom(func() (cnt int64, err error) {
+			// language=SQL
+			err = pgctx.QueryRow(ctx, `
+				select count(*)
+				from collection_works cw
+					join works w on cw.work_id = w.id
+				where cw.collection_id = $1
+				  and w.status = 'published'
+				  and can_view_work(w.user_id, w.visibility, w.status, nullif($2, '')::uuid)
+			`, req.ID, userID).Scan(&cnt)
+			return
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	{
+		// language=SQL
+		rows, err := pgctx.Query(ctx, `
+			select
+				w.id, w.name, w.detail, w.photo, w.tags, w.created_at, w.publish_at, w.pin_order is not null,
+				w.favorite_count, w.comment_count,
+				f.work_id is not null as is_favorite
+			from collection_works cw
+				join works w on cw.work_id = w.id
+				left join favorites f on w.id = f.work_id and ($3 != '' and f.user_id = $3::uuid)
+			where cw.collection_id = $4
+			  and w.status = 'published'
+			  and can_view_work(w.user_id, w.visibility, w.status, nullif($3, '')::uuid)
+			order by cw.position, cw.created_at
+			offset $1 limit $2
+		`, req.Paginate.Offset(), req.Paginate.Limit(), userID, req.ID)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		r.List = make([]*discovery.WorkItem, 0)
+		r.Paginate = req.Paginate
+
+		for rows.Next() {
+			var x discovery.WorkItem
+			err := rows.Scan(
+				&x.ID, &x.Name, &x.Detail, &x.Photo, pq.Array(&x.Tags), &x.CreatedAt, &x.PublishAt, &x.IsPinned,
+				&x.FavoriteCount, &x.CommentCount,
+				&x.IsFavorite,
+			)
+			if err != nil {
+				return nil, err
+			}
+			r.List = append(r.List, &x)
+		}
+
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+
+		rows.Close()
+	}
+
+	return &r, nil
+}
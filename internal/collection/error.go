@@ -0,0 +1,9 @@
+package collection
+
+import (
+	"github.com/acoshift/arpc"
+)
+
+var (
+	errCollectionNotFound = arpc.NewError("collection not found")
+)
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"log"
+	"strings"
 	"time"
 
 	"cloud.google.com/go/profiler"
@@ -19,6 +20,24 @@ func String(name string) string {
 	return config.String(name)
 }
 
+// StringSet returns comma separated config as set of lower case items,
+// def is used when config not set
+func StringSet(name string, def []string) map[string]bool {
+	xs := def
+	if s := config.String(name); s != "" {
+		xs = strings.Split(s, ",")
+	}
+
+	m := make(map[string]bool)
+	for _, x := range xs {
+		x = strings.ToLower(strings.TrimSpace(x))
+		if x != "" {
+			m[x] = true
+		}
+	}
+	return m
+}
+
 var (
 	redisClient   *redis.Client
 	db            *sql.DB
@@ -40,8 +59,11 @@ func init() {
 	db, err = sql.Open("postgres", String("db_dsn"))
 	must(err)
 
-	storageClient, err = storage.NewClient(ctx)
-	must(err)
+	// storage is not required when bucket not configured, ex. in tests
+	if String("storage_bucket") != "" {
+		storageClient, err = storage.NewClient(ctx)
+		must(err)
+	}
 
 	if config.Bool("profiling") {
 		profiler.Start(profiler.Config{
@@ -79,3 +101,48 @@ func Dev() bool {
 func BaseURL() string {
 	return config.String("base_url")
 }
+
+// AccountDeletionGracePeriod is the duration before requested account deletion get purged
+func AccountDeletionGracePeriod() time.Duration {
+	return config.DurationDefault("account_deletion_grace_period", 30*24*time.Hour)
+}
+
+// DataExportExpiry is the duration data export can be downloaded before removed
+func DataExportExpiry() time.Duration {
+	return config.DurationDefault("data_export_expiry", 7*24*time.Hour)
+}
+
+// RegistrationMode is one of open, invite, closed
+func RegistrationMode() string {
+	return config.StringDefault("registration_mode", "open")
+}
+
+// InviteQuota is the maximum invite codes a user can create
+func InviteQuota() int {
+	return config.IntDefault("invite_quota", 5)
+}
+
+// InviteExpiry is the duration before created invite code expires
+func InviteExpiry() time.Duration {
+	return config.DurationDefault("invite_expiry", 7*24*time.Hour)
+}
+
+// UsernameChangeCooldown is the minimum duration between username changes
+func UsernameChangeCooldown() time.Duration {
+	return config.DurationDefault("username_change_cooldown", 30*24*time.Hour)
+}
+
+// UsernameHoldPeriod is the duration old username held for its previous owner
+func UsernameHoldPeriod() time.Duration {
+	return config.DurationDefault("username_hold_period", 90*24*time.Hour)
+}
+
+// FeedStrategy is the following feed strategy, pull or push
+func FeedStrategy() string {
+	return config.StringDefault("feed_strategy", "pull")
+}
+
+// CommentEditWindow is the duration after posted that comment can be edited
+func CommentEditWindow() time.Duration {
+	return config.DurationDefault("comment_edit_window", 15*time.Minute)
+}
@@ -0,0 +1,32 @@
+package counter
+
+import (
+	"context"
+
+	"github.com/acoshift/pgsql/pgctx"
+)
+
+// Repair recomputes works' favorite and comment counts,
+// returns number of repaired works
+func Repair(ctx context.Context) (int64, error) {
+	// language=SQL
+	res, err := pgctx.Exec(ctx, `
+		with t as (
+			select
+				w.id,
+				(select count(*) from favorites where work_id = w.id) as favorite_count,
+				(select count(*) from comments where work_id = w.id and deleted_at is null) as comment_count
+			from works w
+		)
+		update works w
+		set favorite_count = t.favorite_count,
+		    comment_count = t.comment_count
+		from t
+		where w.id = t.id
+		  and (w.favorite_count != t.favorite_count or w.comment_count != t.comment_count)
+	`)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
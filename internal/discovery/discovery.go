@@ -17,13 +17,17 @@ type GetWorksRequest struct {
 }
 
 type WorkItem struct {
-	ID         string           `json:"id"`
-	Name       string           `json:"name"`
-	Detail     string           `json:"detail"`
-	Photo      file.DownloadURL `json:"photo"`
-	Tags       []string         `json:"tags"`
-	CreatedAt  time.Time        `json:"createdAt"`
-	IsFavorite bool             `json:"isFavorite"`
+	ID            string           `json:"id"`
+	Name          string           `json:"name"`
+	Detail        string           `json:"detail"`
+	Photo         file.DownloadURL `json:"photo"`
+	Tags          []string         `json:"tags"`
+	CreatedAt     time.Time        `json:"createdAt"`
+	PublishAt     time.Time        `json:"publishAt"`
+	IsPinned      bool             `json:"isPinned"` // pinned on owner's profile
+	FavoriteCount int64            `json:"favoriteCount"`
+	CommentCount  int64            `json:"commentCount"`
+	IsFavorite    bool             `json:"isFavorite"`
 }
 
 type GetWorksResult struct {
@@ -39,8 +43,13 @@ func GetWorks(ctx context.Context, req *GetWorksRequest) (*GetWorksResult, error
 		err := req.Paginate.CountFrom(func() (cnt int64, err error) {
 			// language=SQL
 			err = pgctx.QueryRow(ctx, `
-				select count(*) from works
-			`).Scan(&cnt)
+				select count(*)
+				from works
+				where status = 'published'
+				  and visibility != 'unlisted'
+				  and can_view_work(user_id, visibility, status, nullif($1, '')::uuid)
+				  and not is_muted(nullif($1, '')::uuid, user_id)
+			`, userID).Scan(&cnt)
 			return
 		})
 		if err != nil {
@@ -52,11 +61,16 @@ func GetWorks(ctx context.Context, req *GetWorksRequest) (*GetWorksResult, error
 		// language=SQL
 		rows, err := pgctx.Query(ctx, `
 			select
-				w.id, w.name, w.detail, w.photo, w.tags, w.created_at,
+				w.id, w.name, w.detail, w.photo, w.tags, w.created_at, w.publish_at, w.pin_order is not null,
+				w.favorite_count, w.comment_count,
 				f.work_id is not null as is_favorite
 			from works w
 				left join favorites f on w.id = f.work_id and ($3 != '' and f.user_id = $3::uuid)
-			order by w.created_at desc
+			where w.status = 'published'
+			  and w.visibility != 'unlisted'
+			  and can_view_work(w.user_id, w.visibility, w.status, nullif($3, '')::uuid)
+			  and not is_muted(nullif($3, '')::uuid, w.user_id)
+			order by w.publish_at desc
 			offset $1 limit $2
 		`, req.Paginate.Offset(), req.Paginate.Limit(), userID)
 		if err != nil {
@@ -70,7 +84,8 @@ func GetWorks(ctx context.Context, req *GetWorksRequest) (*GetWorksResult, error
 		for rows.Next() {
 			var x WorkItem
 			err := rows.Scan(
-				&x.ID, &x.Name, &x.Detail, &x.Photo, pq.Array(&x.Tags), &x.CreatedAt,
+				&x.ID, &x.Name, &x.Detail, &x.Photo, pq.Array(&x.Tags), &x.CreatedAt, &x.PublishAt, &x.IsPinned,
+				&x.FavoriteCount, &x.CommentCount,
 				&x.IsFavorite,
 			)
 			if err != nil {
@@ -0,0 +1,9 @@
+package discovery
+
+import (
+	"github.com/acoshift/arpc"
+)
+
+var (
+	errInvalidCredentials = arpc.NewError("invalid credentials")
+)
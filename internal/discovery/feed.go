@@ -0,0 +1,237 @@
+package discovery
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/acoshift/pgsql/pgctx"
+	"github.com/lib/pq"
+
+	"github.com/acoshift/pikkanode/internal/feed"
+	"github.com/acoshift/pikkanode/internal/session"
+	"github.com/acoshift/pikkanode/internal/validator"
+)
+
+const (
+	defaultFeedLimit = 20
+	maxFeedLimit     = 100
+)
+
+type GetFollowingFeedRequest struct {
+	Cursor string `json:"cursor"` // empty for first page
+	Limit  int    `json:"limit"`
+
+	cursor *feed.Item
+}
+
+func (req *GetFollowingFeedRequest) Valid() error {
+	if req.Limit <= 0 {
+		req.Limit = defaultFeedLimit
+	}
+
+	v := validator.New()
+	v.Must(req.Limit <= maxFeedLimit, "limit maximum 100")
+	if req.Cursor != "" {
+		var err error
+		req.cursor, err = decodeFeedCursor(req.Cursor)
+		v.Must(err == nil, "invalid cursor")
+	}
+
+	return v.Error()
+}
+
+type GetFollowingFeedResult struct {
+	List       []*WorkItem `json:"list"`
+	NextCursor string      `json:"nextCursor"` // empty when no more works
+}
+
+// GetFollowingFeed lists works from followed users, newest first,
+// both strategies use keyset over (publish_at, id) so they return the same pages
+func GetFollowingFeed(ctx context.Context, req *GetFollowingFeedRequest) (*GetFollowingFeedResult, error) {
+	userID := session.GetUserID(ctx)
+	if userID == "" {
+		return nil, errInvalidCredentials
+	}
+
+	// fetch one more work to know whether next page exists
+	var (
+		xs  []*WorkItem
+		err error
+	)
+	if feed.Push() {
+		xs, err = getPushedFeed(ctx, userID, req.cursor, req.Limit+1)
+	} else {
+		xs, err = getPulledFeed(ctx, userID, req.cursor, req.Limit+1)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var r GetFollowingFeedResult
+	r.List = xs
+	if len(xs) > req.Limit {
+		r.List = xs[:req.Limit]
+		last := r.List[len(r.List)-1]
+		id, _ := strconv.ParseInt(last.ID, 10, 64)
+		r.NextCursor = encodeFeedCursor(&feed.Item{ID: id, PublishAt: last.PublishAt})
+	}
+
+	return &r, nil
+}
+
+// feed cursor is the publish time and id of the last work in page
+func encodeFeedCursor(c *feed.Item) string {
+	s := strconv.FormatInt(c.PublishAt.UnixNano(), 10) + ":" + strconv.FormatInt(c.ID, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+func decodeFeedCursor(s string) (*feed.Item, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	xs := strings.SplitN(string(b), ":", 2)
+	if len(xs) != 2 {
+		return nil, strconv.ErrSyntax
+	}
+
+	n, err := strconv.ParseInt(xs[0], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	id, err := strconv.ParseInt(xs[1], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	return &feed.Item{ID: id, PublishAt: time.Unix(0, n).UTC()}, nil
+}
+
+// language=SQL
+const feedSelect = `
+	select
+		w.id, w.name, w.detail, w.photo, w.tags, w.created_at, w.publish_at, w.pin_order is not null,
+		w.favorite_count, w.comment_count,
+		f.work_id is not null as is_favorite
+`
+
+// language=SQL
+const feedFilter = `
+	and not is_muted($1, w.user_id)
+	and w.status = 'published'
+	and w.visibility != 'unlisted'
+	and can_view_work(w.user_id, w.visibility, w.status, $1)
+`
+
+func scanFeedWork(rows *sql.Rows) (*WorkItem, error) {
+	var x WorkItem
+	err := rows.Scan(
+		&x.ID, &x.Name, &x.Detail, &x.Photo, pq.Array(&x.Tags), &x.CreatedAt, &x.PublishAt, &x.IsPinned,
+		&x.FavoriteCount, &x.CommentCount,
+		&x.IsFavorite,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &x, nil
+}
+
+func getPulledFeed(ctx context.Context, userID string, cursor *feed.Item, limit int) ([]*WorkItem, error) {
+	var (
+		cursorAt *time.Time
+		cursorID *int64
+	)
+	if cursor != nil {
+		cursorAt = &cursor.PublishAt
+		cursorID = &cursor.ID
+	}
+
+	// language=SQL
+	rows, err := pgctx.Query(ctx, feedSelect+`
+		from works w
+			left join favorites f on w.id = f.work_id and f.user_id = $1
+		where w.user_id in (select following_id from follows where user_id = $1)
+		  `+feedFilter+`
+		  and ($2::timestamp is null or (w.publish_at, w.id) < ($2, $3::bigint))
+		order by w.publish_at desc, w.id desc
+		limit $4
+	`, userID, cursorAt, cursorID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	xs := make([]*WorkItem, 0)
+	for rows.Next() {
+		x, err := scanFeedWork(rows)
+		if err != nil {
+			return nil, err
+		}
+		xs = append(xs, x)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return xs, nil
+}
+
+func getPushedFeed(ctx context.Context, userID string, cursor *feed.Item, limit int) ([]*WorkItem, error) {
+	xs := make([]*WorkItem, 0)
+
+	// removed, not listed and hidden works, and works from muted users are skipped,
+	// read more from feed until page filled
+	for len(xs) < limit {
+		n := limit - len(xs)
+		items, err := feed.Get(userID, cursor, int64(n))
+		if err != nil {
+			return nil, err
+		}
+		if len(items) == 0 {
+			break
+		}
+		cursor = items[len(items)-1]
+
+		ids := make([]int64, 0, len(items))
+		for _, it := range items {
+			ids = append(ids, it.ID)
+		}
+
+		// language=SQL
+		rows, err := pgctx.Query(ctx, feedSelect+`
+			from unnest($2::bigint[]) with ordinality as t (id, ord)
+				join works w on w.id = t.id
+				left join favorites f on w.id = f.work_id and f.user_id = $1
+			where w.user_id in (select following_id from follows where user_id = $1)
+			  `+feedFilter+`
+			order by t.ord
+		`, userID, pq.Array(ids))
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			x, err := scanFeedWork(rows)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			xs = append(xs, x)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(items) < n {
+			break
+		}
+	}
+
+	return xs, nil
+}
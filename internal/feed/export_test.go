@@ -0,0 +1,18 @@
+package feed
+
+// SetStrategy changes feed strategy, returns previous strategy
+func SetStrategy(s string) string {
+	prev := strategy
+	strategy = s
+	return prev
+}
+
+// Ping checks redis connection
+func Ping() error {
+	return redisClient.Ping().Err()
+}
+
+// Clear removes user's pushed feed
+func Clear(userID string) {
+	redisClient.Del(key(userID))
+}
@@ -0,0 +1,284 @@
+package feed
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/acoshift/pgsql/pgctx"
+	"github.com/go-redis/redis"
+
+	"github.com/acoshift/pikkanode/internal/config"
+)
+
+// Feed strategies
+//
+// pull queries works from followed users when reading feed,
+// push fans out work ids into followers' redis sorted set when writing
+const (
+	StrategyPull = "pull"
+	StrategyPush = "push"
+)
+
+// maxSize is the maximum works keep in pushed feed
+const maxSize = 1000
+
+// backfillSize is the number of works added to feed when follow a user
+const backfillSize = 50
+
+var (
+	redisClient = config.RedisClient()
+	strategy    = config.FeedStrategy()
+)
+
+func key(userID string) string {
+	return config.RedisPrefix() + "feed:" + userID
+}
+
+// member returns zero-padded work id,
+// works with the same score are sorted by member, padded ids sort as numbers
+func member(workID int64) string {
+	return fmt.Sprintf("%019d", workID)
+}
+
+// score returns publish time in microseconds, the precision of database timestamp
+func score(publishAt time.Time) float64 {
+	return float64(publishAt.Round(time.Microsecond).UnixNano() / int64(time.Microsecond))
+}
+
+func formatScore(s float64) string {
+	return strconv.FormatFloat(s, 'f', -1, 64)
+}
+
+// Push returns true if using push strategy
+func Push() bool {
+	return strategy == StrategyPush
+}
+
+// AddWork fans out published work to author's followers
+func AddWork(ctx context.Context, userID string, workID int64, publishAt time.Time) error {
+	if !Push() {
+		return nil
+	}
+
+	followerIDs, err := getFollowerIDs(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = redisClient.Pipelined(func(p redis.Pipeliner) error {
+		for _, id := range followerIDs {
+			addToFeed(p, id, redis.Z{Score: score(publishAt), Member: member(workID)})
+		}
+		return nil
+	})
+	return err
+}
+
+// RemoveWork removes work from author's followers feed
+func RemoveWork(ctx context.Context, userID string, workID string) error {
+	if !Push() {
+		return nil
+	}
+
+	id, err := strconv.ParseInt(workID, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	followerIDs, err := getFollowerIDs(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = redisClient.Pipelined(func(p redis.Pipeliner) error {
+		for _, followerID := range followerIDs {
+			p.ZRem(key(followerID), member(id))
+		}
+		return nil
+	})
+	return err
+}
+
+// Follow backfills followed user's latest published works into user's feed
+func Follow(ctx context.Context, userID, followingID string) error {
+	if !Push() {
+		return nil
+	}
+
+	// language=SQL
+	rows, err := pgctx.Query(ctx, `
+		select id, publish_at
+		from works
+		where user_id = $1 and status = 'published'
+		order by publish_at desc, id desc
+		limit $2
+	`, followingID, backfillSize)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var xs []redis.Z
+	for rows.Next() {
+		var (
+			id        int64
+			publishAt time.Time
+		)
+		err := rows.Scan(&id, &publishAt)
+		if err != nil {
+			return err
+		}
+		xs = append(xs, redis.Z{Score: score(publishAt), Member: member(id)})
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows.Close()
+
+	if len(xs) == 0 {
+		return nil
+	}
+
+	_, err = redisClient.Pipelined(func(p redis.Pipeliner) error {
+		addToFeed(p, userID, xs...)
+		return nil
+	})
+	return err
+}
+
+// Unfollow removes unfollowed user's works from user's feed
+func Unfollow(ctx context.Context, userID, followingID string) error {
+	if !Push() {
+		return nil
+	}
+
+	// language=SQL
+	rows, err := pgctx.Query(ctx, `
+		select id
+		from works
+		where user_id = $1 and status = 'published'
+		order by publish_at desc, id desc
+		limit $2
+	`, followingID, maxSize)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var ids []interface{}
+	for rows.Next() {
+		var id int64
+		err := rows.Scan(&id)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, member(id))
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows.Close()
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	return redisClient.ZRem(key(userID), ids...).Err()
+}
+
+func getFollowerIDs(ctx context.Context, userID string) ([]string, error) {
+	// language=SQL
+	rows, err := pgctx.Query(ctx, `
+		select user_id from follows where following_id = $1
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var xs []string
+	for rows.Next() {
+		var id string
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		xs = append(xs, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return xs, nil
+}
+
+func addToFeed(p redis.Pipeliner, userID string, xs ...redis.Z) {
+	k := key(userID)
+	p.ZAdd(k, xs...)
+	p.ZRemRangeByRank(k, 0, -maxSize-1)
+}
+
+// Item is a work in user's pushed feed
+type Item struct {
+	ID        int64
+	PublishAt time.Time
+}
+
+// Get returns works in user's pushed feed published before cursor, newest first,
+// works with the same publish time are ordered by id, same as pulled feed
+func Get(userID string, cursor *Item, limit int64) ([]*Item, error) {
+	var xs []redis.Z
+	max := "+inf"
+	if cursor != nil {
+		s := formatScore(score(cursor.PublishAt))
+
+		// works published at the same time as cursor, only few works, filtered by member
+		zs, err := redisClient.ZRevRangeByScoreWithScores(key(userID), redis.ZRangeBy{
+			Max: s,
+			Min: s,
+		}).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, z := range zs {
+			if z.Member.(string) < member(cursor.ID) {
+				xs = append(xs, z)
+			}
+		}
+		if int64(len(xs)) > limit {
+			xs = xs[:limit]
+		}
+
+		max = "(" + s
+	}
+
+	if n := limit - int64(len(xs)); n > 0 {
+		zs, err := redisClient.ZRevRangeByScoreWithScores(key(userID), redis.ZRangeBy{
+			Max:   max,
+			Min:   "-inf",
+			Count: n,
+		}).Result()
+		if err != nil {
+			return nil, err
+		}
+		xs = append(xs, zs...)
+	}
+
+	items := make([]*Item, 0, len(xs))
+	for _, z := range xs {
+		s, _ := z.Member.(string)
+		id, _ := strconv.ParseInt(s, 10, 64)
+		if id <= 0 {
+			continue
+		}
+		items = append(items, &Item{
+			ID:        id,
+			PublishAt: time.Unix(0, int64(z.Score)*int64(time.Microsecond)).UTC(),
+		})
+	}
+	return items, nil
+}
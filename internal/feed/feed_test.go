@@ -0,0 +1,240 @@
+package feed_test
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/acoshift/pgsql/pgctx"
+
+	"github.com/acoshift/pikkanode/internal/discovery"
+	"github.com/acoshift/pikkanode/internal/feed"
+	"github.com/acoshift/pikkanode/internal/me"
+	"github.com/acoshift/pikkanode/internal/testdb"
+	"github.com/acoshift/pikkanode/internal/user"
+)
+
+func TestMain(m *testing.M) {
+	testdb.Main(m)
+}
+
+type seededWork struct {
+	ID        int64
+	UserID    string
+	PublishAt time.Time
+	Listed    bool // published and visible to follower in list
+}
+
+func seedWorks(ctx context.Context, t *testing.T, userID string, base time.Time, n int) []*seededWork {
+	t.Helper()
+
+	var xs []*seededWork
+	for i := 0; i < n; i++ {
+		x := seededWork{
+			UserID: userID,
+			// two works share the same publish time to check ordering by id
+			PublishAt: base.Add(time.Duration(i/2) * time.Minute),
+			Listed:    true,
+		}
+
+		visibility, status := "public", "published"
+		publishAt := &x.PublishAt
+		switch i {
+		case 3:
+			visibility, x.Listed = "unlisted", false
+		case 5:
+			visibility, x.Listed = "private", false
+		case 7:
+			visibility = "followers"
+		case 9:
+			status, publishAt, x.Listed = "draft", nil, false
+		}
+
+		// language=SQL
+		err := pgctx.QueryRow(ctx, `
+			insert into works
+				(user_id, name, photo, visibility, status, publish_at)
+			values
+				($1, $2, '', $3, $4, $5)
+			returning id
+		`, userID, "work"+strconv.Itoa(i), visibility, status, publishAt).Scan(&x.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if status == "published" {
+			err = feed.AddWork(ctx, userID, x.ID, x.PublishAt)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		xs = append(xs, &x)
+	}
+	return xs
+}
+
+// expectedFeed returns listed works from users, newest first
+func expectedFeed(works []*seededWork, userIDs map[string]bool) []string {
+	var xs []*seededWork
+	for _, x := range works {
+		if x.Listed && userIDs[x.UserID] {
+			xs = append(xs, x)
+		}
+	}
+	sort.Slice(xs, func(i, j int) bool {
+		if !xs[i].PublishAt.Equal(xs[j].PublishAt) {
+			return xs[i].PublishAt.After(xs[j].PublishAt)
+		}
+		return xs[i].ID > xs[j].ID
+	})
+
+	ids := make([]string, 0, len(xs))
+	for _, x := range xs {
+		ids = append(ids, strconv.FormatInt(x.ID, 10))
+	}
+	return ids
+}
+
+// feedPages reads all pages of following feed
+func feedPages(ctx context.Context, t *testing.T, limit int) [][]string {
+	t.Helper()
+
+	var pages [][]string
+	var cursor string
+	for {
+		req := discovery.GetFollowingFeedRequest{Cursor: cursor, Limit: limit}
+		err := req.Valid()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := discovery.GetFollowingFeed(ctx, &req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		page := make([]string, 0, len(r.List))
+		for _, x := range r.List {
+			page = append(page, x.ID)
+		}
+		pages = append(pages, page)
+
+		if r.NextCursor == "" {
+			return pages
+		}
+		if len(page) != limit {
+			t.Fatalf("page %d has %d works; want %d", len(pages), len(page), limit)
+		}
+		if len(pages) > 100 {
+			t.Fatal("too many pages")
+		}
+		cursor = r.NextCursor
+	}
+}
+
+func flatten(pages [][]string) []string {
+	xs := make([]string, 0)
+	for _, p := range pages {
+		xs = append(xs, p...)
+	}
+	return xs
+}
+
+func TestFollowingFeedStrategies(t *testing.T) {
+	ctx := testdb.Context(t)
+	if err := feed.Ping(); err != nil {
+		t.Skipf("redis not available; %v", err)
+	}
+
+	prev := feed.SetStrategy(feed.StrategyPush)
+	defer feed.SetStrategy(prev)
+
+	viewerID, _ := testdb.CreateUser(ctx, t)
+	defer feed.Clear(viewerID)
+	viewerCtx := testdb.WithUser(ctx, viewerID)
+
+	follow := func(username string, follow bool) {
+		t.Helper()
+
+		_, err := user.Follow(viewerCtx, &user.FollowRequest{Username: username, Follow: follow})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	var (
+		works     []*seededWork
+		following = make(map[string]bool)
+		userIDs   = make(map[string]string)
+		usernames = make(map[string]string)
+	)
+
+	// followed before works published, works are fanned out
+	for _, name := range []string{"a", "b", "c"} {
+		id, username := testdb.CreateUser(ctx, t)
+		userIDs[name], usernames[name] = id, username
+		follow(username, true)
+		following[id] = true
+		works = append(works, seedWorks(ctx, t, id, base, 12)...)
+	}
+
+	// followed after works published, works are backfilled
+	{
+		id, username := testdb.CreateUser(ctx, t)
+		userIDs["d"], usernames["d"] = id, username
+		works = append(works, seedWorks(ctx, t, id, base.Add(30*time.Second), 12)...)
+		follow(username, true)
+		following[id] = true
+	}
+
+	// not followed
+	{
+		id, _ := testdb.CreateUser(ctx, t)
+		works = append(works, seedWorks(ctx, t, id, base, 4)...)
+	}
+
+	check := func(step string) {
+		t.Helper()
+
+		want := expectedFeed(works, following)
+		for _, limit := range []int{1, 4, 7, 100} {
+			feed.SetStrategy(feed.StrategyPull)
+			pulled := feedPages(viewerCtx, t, limit)
+			feed.SetStrategy(feed.StrategyPush)
+			pushed := feedPages(viewerCtx, t, limit)
+
+			if !reflect.DeepEqual(pulled, pushed) {
+				t.Errorf("%s: limit %d; pull and push pages differ\npull: %v\npush: %v", step, limit, pulled, pushed)
+			}
+			if got := flatten(pulled); !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: limit %d; got %v; want %v", step, limit, got, want)
+			}
+		}
+	}
+
+	check("follow")
+
+	follow(usernames["b"], false)
+	delete(following, userIDs["b"])
+	check("unfollow")
+
+	_, err := me.Block(viewerCtx, &me.BlockRequest{Username: usernames["c"], Block: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(following, userIDs["c"])
+	check("block")
+
+	_, err = me.Mute(viewerCtx, &me.MuteRequest{Username: usernames["a"], Mute: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// muted user is still followed, but works are not listed
+	delete(following, userIDs["a"])
+	check("mute")
+}
@@ -8,6 +8,7 @@ import (
 	"path"
 	"strings"
 
+	"cloud.google.com/go/storage"
 	"github.com/gofrs/uuid"
 
 	"github.com/acoshift/pikkanode/internal/config"
@@ -23,11 +24,24 @@ var (
 
 const BasePath = "/u"
 
+// privateDir contains files not served by public handler, ex. data exports
+const privateDir = "private"
+
 func GenerateFilename(ext string) string {
 	ext = strings.TrimPrefix(ext, ".")
 	return uuid.Must(uuid.NewV4()).String() + "." + ext
 }
 
+// GeneratePrivateFilename generates filename for file that only its owner can access,
+// the file must be served by handler checking its owner
+func GeneratePrivateFilename(ext string) string {
+	return path.Join(privateDir, GenerateFilename(ext))
+}
+
+func isPrivate(filename string) bool {
+	return strings.HasPrefix(path.Clean("/"+filename), "/"+privateDir+"/")
+}
+
 func Serve(ctx context.Context, w http.ResponseWriter, filename string) error {
 	fn := path.Join(bucketBasePath, filename)
 
@@ -47,6 +61,10 @@ func Serve(ctx context.Context, w http.ResponseWriter, filename string) error {
 func Handler() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		filename := r.URL.Path
+		if isPrivate(filename) {
+			http.NotFound(w, r)
+			return
+		}
 		Serve(r.Context(), w, filename)
 	})
 }
@@ -73,6 +91,22 @@ func Store(ctx context.Context, f File) error {
 	return w.Close()
 }
 
+// Open opens stored file
+func Open(ctx context.Context, filename string) (io.ReadCloser, error) {
+	fn := path.Join(bucketBasePath, filename)
+	return bucketHandle.Object(fn).NewReader(ctx)
+}
+
+// Remove removes stored file, not exists file will be ignored
+func Remove(ctx context.Context, filename string) error {
+	fn := path.Join(bucketBasePath, filename)
+	err := bucketHandle.Object(fn).Delete(ctx)
+	if err == storage.ErrObjectNotExist {
+		return nil
+	}
+	return err
+}
+
 type DownloadURL string
 
 func (s DownloadURL) MarshalJSON() ([]byte, error) {
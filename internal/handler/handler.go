@@ -7,17 +7,25 @@ import (
 	"github.com/acoshift/middleware"
 	"github.com/acoshift/pgsql/pgctx"
 
+	"github.com/acoshift/pikkanode/internal/admin"
 	"github.com/acoshift/pikkanode/internal/auth"
+	"github.com/acoshift/pikkanode/internal/collection"
 	"github.com/acoshift/pikkanode/internal/config"
 	"github.com/acoshift/pikkanode/internal/discovery"
 	"github.com/acoshift/pikkanode/internal/file"
 	"github.com/acoshift/pikkanode/internal/me"
 	"github.com/acoshift/pikkanode/internal/session"
+	"github.com/acoshift/pikkanode/internal/token"
 	"github.com/acoshift/pikkanode/internal/user"
 	"github.com/acoshift/pikkanode/internal/work"
 )
 
 func New() http.Handler {
+	// access token scopes, handler without scope only allow session cookie
+	read := token.Scope(token.ScopeRead)
+	write := token.Scope(token.ScopeWrite)
+	upload := token.Scope(token.ScopeUpload)
+
 	mux := http.NewServeMux()
 	mux.Handle("/", arpc.NotFoundHandler())
 	mux.Handle(file.BasePath+"/", http.StripPrefix(file.BasePath, file.Handler()))
@@ -25,26 +33,84 @@ func New() http.Handler {
 	mux.Handle("/auth/signUp", arpc.Handler(auth.SignUp))
 	mux.Handle("/auth/signIn", arpc.Handler(auth.SignIn))
 	mux.Handle("/auth/signOut", arpc.Handler(auth.SignOut))
-	mux.Handle("/auth/check", arpc.Handler(auth.Check))
+	mux.Handle("/auth/check", read(arpc.Handler(auth.Check)))
+	mux.Handle("/auth/oidc/login", auth.OIDCLoginHandler())
+	mux.Handle("/auth/oidc/callback", auth.OIDCCallbackHandler())
+
+	mux.Handle("/me/profile", read(arpc.Handler(me.Profile)))
+	mux.Handle("/me/updateProfile", write(arpc.Handler(me.UpdateProfile)))
+	mux.Handle("/me/uploadProfilePhoto", upload(arpc.Handler(me.UploadProfilePhoto)))
+	mux.Handle("/me/uploadProfileBanner", upload(arpc.Handler(me.UploadProfileBanner)))
+	mux.Handle("/me/removeWork", write(arpc.Handler(me.RemoveWork)))
+	mux.Handle("/me/getMyWorks", read(arpc.Handler(me.GetMyWorks)))
+	mux.Handle("/me/getMyFavoriteWorks", read(arpc.Handler(me.GetMyFavoriteWorks)))
+	mux.Handle("/me/getFavoriteStats", read(arpc.Handler(me.GetFavoriteStats)))
+	mux.Handle("/me/getNotifications", read(arpc.Handler(me.GetNotifications)))
+	mux.Handle("/me/readNotifications", write(arpc.Handler(me.ReadNotifications)))
+	mux.Handle("/me/createWork", upload(arpc.Handler(me.CreateWork)))
+	mux.Handle("/me/updateWork", write(arpc.Handler(me.UpdateWork)))
+	mux.Handle("/me/getDrafts", read(arpc.Handler(me.GetDrafts)))
+	mux.Handle("/me/publishWork", write(arpc.Handler(me.PublishWork)))
+	mux.Handle("/me/pinWork", write(arpc.Handler(me.PinWork)))
+	mux.Handle("/me/unpinWork", write(arpc.Handler(me.UnpinWork)))
+	mux.Handle("/me/createAccessToken", arpc.Handler(me.CreateAccessToken))
+	mux.Handle("/me/getAccessTokens", arpc.Handler(me.GetAccessTokens))
+	mux.Handle("/me/revokeAccessToken", arpc.Handler(me.RevokeAccessToken))
+	mux.Handle("/me/getSessions", arpc.Handler(me.GetSessions))
+	mux.Handle("/me/revokeSession", arpc.Handler(me.RevokeSession))
+	mux.Handle("/me/revokeOtherSessions", arpc.Handler(me.RevokeOtherSessions))
+	mux.Handle("/me/changePassword", arpc.Handler(me.ChangePassword))
+	mux.Handle("/me/requestAccountDeletion", arpc.Handler(me.RequestAccountDeletion))
+	mux.Handle("/me/cancelAccountDeletion", arpc.Handler(me.CancelAccountDeletion))
+	mux.Handle("/me/exportData", arpc.Handler(me.ExportData))
+	mux.Handle("/me/downloadDataExport", me.DownloadDataExportHandler())
+	mux.Handle("/me/createInvite", arpc.Handler(me.CreateInvite))
+	mux.Handle("/me/getInvites", arpc.Handler(me.GetInvites))
+	mux.Handle("/me/changeUsername", arpc.Handler(me.ChangeUsername))
+	mux.Handle("/me/block", write(arpc.Handler(me.Block)))
+	mux.Handle("/me/mute", write(arpc.Handler(me.Mute)))
+	mux.Handle("/me/getBlockedUsers", read(arpc.Handler(me.GetBlockedUsers)))
+	mux.Handle("/me/getMutedUsers", read(arpc.Handler(me.GetMutedUsers)))
+	mux.Handle("/me/setPrivate", write(arpc.Handler(me.SetPrivate)))
+	mux.Handle("/me/getFollowRequests", read(arpc.Handler(me.GetFollowRequests)))
+	mux.Handle("/me/approveFollowRequest", write(arpc.Handler(me.ApproveFollowRequest)))
+	mux.Handle("/me/rejectFollowRequest", write(arpc.Handler(me.RejectFollowRequest)))
+	mux.Handle("/me/getCollections", read(arpc.Handler(me.GetCollections)))
+	mux.Handle("/me/createCollection", write(arpc.Handler(me.CreateCollection)))
+	mux.Handle("/me/updateCollection", write(arpc.Handler(me.UpdateCollection)))
+	mux.Handle("/me/deleteCollection", write(arpc.Handler(me.DeleteCollection)))
+	mux.Handle("/me/reorderCollections", write(arpc.Handler(me.ReorderCollections)))
+	mux.Handle("/me/addCollectionWork", write(arpc.Handler(me.AddCollectionWork)))
+	mux.Handle("/me/removeCollectionWork", write(arpc.Handler(me.RemoveCollectionWork)))
+	mux.Handle("/me/reorderCollectionWorks", write(arpc.Handler(me.ReorderCollectionWorks)))
+
+	mux.Handle("/user/profile", read(arpc.Handler(user.Profile)))
+	mux.Handle("/user/follow", write(arpc.Handler(user.Follow)))
+	mux.Handle("/user/getWorks", read(arpc.Handler(user.GetWorks)))
+	mux.Handle("/user/getFollowers", read(arpc.Handler(user.GetFollowers)))
+	mux.Handle("/user/getFollowing", read(arpc.Handler(user.GetFollowing)))
 
-	mux.Handle("/me/profile", arpc.Handler(me.Profile))
-	mux.Handle("/me/uploadProfilePhoto", arpc.Handler(me.UploadProfilePhoto))
-	mux.Handle("/me/removeWork", arpc.Handler(me.RemoveWork))
-	mux.Handle("/me/getMyWorks", arpc.Handler(me.GetMyWorks))
-	mux.Handle("/me/getMyFavoriteWorks", arpc.Handler(me.GetMyFavoriteWorks))
-	mux.Handle("/me/createWork", arpc.Handler(me.CreateWork))
-	mux.Handle("/me/updateWork", arpc.Handler(me.UpdateWork))
+	mux.Handle("/work/get", read(arpc.Handler(work.Get)))
+	mux.Handle("/work/favorite", write(arpc.Handler(work.Favorite)))
+	mux.Handle("/work/getFavoriters", read(arpc.Handler(work.GetFavoriters)))
+	mux.Handle("/work/react", write(arpc.Handler(work.React)))
+	mux.Handle("/work/reactComment", write(arpc.Handler(work.ReactComment)))
+	mux.Handle("/work/postComment", write(arpc.Handler(work.PostComment)))
+	mux.Handle("/work/getComments", read(arpc.Handler(work.GetComments)))
+	mux.Handle("/work/getReplies", read(arpc.Handler(work.GetReplies)))
+	mux.Handle("/work/editComment", write(arpc.Handler(work.EditComment)))
+	mux.Handle("/work/deleteComment", write(arpc.Handler(work.DeleteComment)))
+	mux.Handle("/work/hideComment", write(arpc.Handler(work.HideComment)))
 
-	mux.Handle("/user/profile", arpc.Handler(user.Profile))
-	mux.Handle("/user/follow", arpc.Handler(user.Follow))
+	mux.Handle("/collection/get", read(arpc.Handler(collection.Get)))
 
-	mux.Handle("/work/get", arpc.Handler(work.Get))
-	mux.Handle("/work/favorite", arpc.Handler(work.Favorite))
-	mux.Handle("/work/postComment", arpc.Handler(work.PostComment))
+	mux.Handle("/discovery/getWorks", read(arpc.Handler(discovery.GetWorks)))
+	mux.Handle("/discovery/getFollowingFeed", read(arpc.Handler(discovery.GetFollowingFeed)))
 
-	mux.Handle("/discovery/getWorks", arpc.Handler(discovery.GetWorks))
+	mux.Handle("/admin/createInvites", arpc.Handler(admin.CreateInvites))
 	return middleware.Chain(
 		session.Middleware(),
 		pgctx.Middleware(config.DB()),
+		token.Middleware(),
 	)(mux)
 }
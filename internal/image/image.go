@@ -61,7 +61,17 @@ func ContentType(ext string) string {
 
 var sem = semaphore.NewWeighted(10)
 
+// Profile crops image for profile photo
 func Profile(ctx context.Context, w io.Writer, r io.Reader, ext string) error {
+	return fill(ctx, w, r, ext, 250, 250)
+}
+
+// Banner crops image for profile banner
+func Banner(ctx context.Context, w io.Writer, r io.Reader, ext string) error {
+	return fill(ctx, w, r, ext, 1500, 500)
+}
+
+func fill(ctx context.Context, w io.Writer, r io.Reader, ext string, width, height int) error {
 	ft, err := imaging.FormatFromExtension(ext)
 	if err != nil {
 		return ErrInvalidType
@@ -83,7 +93,7 @@ func Profile(ctx context.Context, w io.Writer, r io.Reader, ext string) error {
 		return ErrInvalidType
 	}
 
-	img = imaging.Fill(img, 250, 250, imaging.Center, imaging.Lanczos)
+	img = imaging.Fill(img, width, height, imaging.Center, imaging.Lanczos)
 	return imaging.Encode(w, img, ft)
 }
 
@@ -0,0 +1,76 @@
+package invite
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"time"
+
+	"github.com/acoshift/pgsql/pgctx"
+)
+
+// Registration modes
+const (
+	ModeOpen   = "open"
+	ModeInvite = "invite"
+	ModeClosed = "closed"
+)
+
+var (
+	ErrInvalidCode = errors.New("invite: invalid code")
+)
+
+func generateCode() string {
+	b := make([]byte, 5)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return base32.StdEncoding.EncodeToString(b)
+}
+
+// Create creates new invite code,
+// userID can be empty for invite not created by user
+func Create(ctx context.Context, userID string, maxUses int, expiresAt time.Time) (code string, err error) {
+	// retry when generated code duplicated
+	for i := 0; i < 5; i++ {
+		// language=SQL
+		err = pgctx.QueryRow(ctx, `
+			insert into invites
+				(code, user_id, max_uses, expires_at)
+			values
+				($1, nullif($2, '')::uuid, $3, $4)
+			on conflict do nothing
+			returning code
+		`, generateCode(), userID, maxUses, expiresAt).Scan(&code)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		return
+	}
+	return "", errors.New("invite: can not generate code")
+}
+
+// Use marks invite code as used,
+// must be called in the same transaction with user creation
+func Use(ctx context.Context, code string) error {
+	if code == "" {
+		return ErrInvalidCode
+	}
+
+	// language=SQL
+	err := pgctx.QueryRow(ctx, `
+		update invites
+		set uses = uses + 1
+		where code = $1
+		  and uses < max_uses
+		  and expires_at > now()
+		returning code
+	`, code).Scan(&code)
+	if err == sql.ErrNoRows {
+		return ErrInvalidCode
+	}
+	return err
+}
@@ -0,0 +1,144 @@
+package me
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/acoshift/pgsql/pgctx"
+	"github.com/asaskevich/govalidator"
+	"github.com/lib/pq"
+
+	"github.com/acoshift/pikkanode/internal/session"
+	"github.com/acoshift/pikkanode/internal/token"
+	"github.com/acoshift/pikkanode/internal/validator"
+)
+
+type CreateAccessTokenRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+func (req *CreateAccessTokenRequest) Valid() error {
+	v := validator.New()
+	v.Must(req.Name != "", "name required")
+	v.Must(utf8.RuneCountInString(req.Name) <= 64, "name maximum 64 characters")
+	v.Must(len(req.Scopes) > 0, "scopes required")
+	for i, s := range req.Scopes {
+		v.Must(token.ValidScope(s), fmt.Sprintf("scopes[%d] is not valid scope", i))
+	}
+
+	return v.Error()
+}
+
+type CreateAccessTokenResult struct {
+	ID     string   `json:"id"`
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	Token  string   `json:"token"`
+}
+
+func CreateAccessToken(ctx context.Context, req *CreateAccessTokenRequest) (*CreateAccessTokenResult, error) {
+	userID := session.GetUserID(ctx)
+	if userID == "" {
+		return nil, errInvalidCredentials
+	}
+
+	tk, hashed, err := token.Generate()
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := insertAccessToken(ctx, userID, req.Name, hashed, req.Scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	var r CreateAccessTokenResult
+	r.ID = id
+	r.Name = req.Name
+	r.Scopes = req.Scopes
+	r.Token = tk
+	return &r, nil
+}
+
+type AccessTokenItem struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	LastUsedAt *time.Time `json:"lastUsedAt"` // updated at most once a minute
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+type GetAccessTokensResult struct {
+	List []*AccessTokenItem `json:"list"`
+}
+
+func GetAccessTokens(ctx context.Context, _ *struct{}) (*GetAccessTokensResult, error) {
+	userID := session.GetUserID(ctx)
+	if userID == "" {
+		return nil, errInvalidCredentials
+	}
+
+	// language=SQL
+	rows, err := pgctx.Query(ctx, `
+		select
+			id, name, scopes, last_used_at, created_at
+		from access_tokens
+		where user_id = $1
+		order by created_at desc
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var r GetAccessTokensResult
+	r.List = make([]*AccessTokenItem, 0)
+	for rows.Next() {
+		var x AccessTokenItem
+		err := rows.Scan(
+			&x.ID, &x.Name, pq.Array(&x.Scopes), &x.LastUsedAt, &x.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		r.List = append(r.List, &x)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+type RevokeAccessTokenRequest struct {
+	ID string `json:"id"`
+}
+
+func (req *RevokeAccessTokenRequest) Valid() error {
+	v := validator.New()
+	v.Must(req.ID != "", "id required")
+	v.Must(govalidator.IsUUID(req.ID), "invalid id")
+
+	return v.Error()
+}
+
+func RevokeAccessToken(ctx context.Context, req *RevokeAccessTokenRequest) (*struct{}, error) {
+	userID := session.GetUserID(ctx)
+	if userID == "" {
+		return nil, errInvalidCredentials
+	}
+
+	// language=SQL
+	_, err := pgctx.Exec(ctx, `
+		delete from access_tokens where user_id = $1 and id = $2
+	`, userID, req.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(struct{}), nil
+}
@@ -0,0 +1,79 @@
+package me
+
+import (
+	"context"
+	"time"
+	"unicode/utf8"
+
+	"github.com/acoshift/pgsql/pgctx"
+
+	"github.com/acoshift/pikkanode/internal/config"
+	"github.com/acoshift/pikkanode/internal/password"
+	"github.com/acoshift/pikkanode/internal/session"
+	"github.com/acoshift/pikkanode/internal/validator"
+)
+
+type RequestAccountDeletionRequest struct {
+	Password string `json:"password"`
+}
+
+func (req *RequestAccountDeletionRequest) Valid() error {
+	v := validator.New()
+	v.Must(utf8.RuneCountInString(req.Password) <= 500, "password maximum 500 characters")
+
+	return v.Error()
+}
+
+type RequestAccountDeletionResult struct {
+	DeleteAt time.Time `json:"deleteAt"`
+}
+
+func RequestAccountDeletion(ctx context.Context, req *RequestAccountDeletionRequest) (*RequestAccountDeletionResult, error) {
+	userID := session.GetUserID(ctx)
+	if userID == "" {
+		return nil, errInvalidCredentials
+	}
+
+	hashed, err := getUserPassword(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// user signed up using oidc do not have password
+	if hashed != "" && !password.Compare(hashed, req.Password) {
+		return nil, errInvalidPassword
+	}
+
+	var r RequestAccountDeletionResult
+	// language=SQL
+	err = pgctx.QueryRow(ctx, `
+		update users
+		set delete_at = coalesce(delete_at, now() + $2 * interval '1 second')
+		where id = $1
+		returning delete_at
+	`, userID, int64(config.AccountDeletionGracePeriod()/time.Second)).Scan(&r.DeleteAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+func CancelAccountDeletion(ctx context.Context, _ *struct{}) (*struct{}, error) {
+	userID := session.GetUserID(ctx)
+	if userID == "" {
+		return nil, errInvalidCredentials
+	}
+
+	// language=SQL
+	_, err := pgctx.Exec(ctx, `
+		update users
+		set delete_at = null
+		where id = $1
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(struct{}), nil
+}
@@ -0,0 +1,262 @@
+package me
+
+import (
+	"context"
+	"unicode/utf8"
+
+	"github.com/acoshift/pgsql/pgctx"
+
+	"github.com/acoshift/pikkanode/internal/feed"
+	"github.com/acoshift/pikkanode/internal/file"
+	"github.com/acoshift/pikkanode/internal/paginate"
+	"github.com/acoshift/pikkanode/internal/session"
+	"github.com/acoshift/pikkanode/internal/validator"
+)
+
+type BlockRequest struct {
+	Username string `json:"username"`
+	Block    bool   `json:"block"`
+}
+
+func (req *BlockRequest) Valid() error {
+	v := validator.New()
+	v.Must(req.Username != "", "username required")
+	v.Must(utf8.RuneCountInString(req.Username) <= 15, "username maximum 15 characters")
+
+	return v.Error()
+}
+
+// Block blocks or unblocks user,
+// blocking removes follows in both directions
+func Block(ctx context.Context, req *BlockRequest) (*struct{}, error) {
+	userID := session.GetUserID(ctx)
+	if userID == "" {
+		return nil, errInvalidCredentials
+	}
+
+	blockingID, err := getUserIDFromUsername(ctx, req.Username)
+	if err == errNotFound {
+		return nil, errUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if userID == blockingID {
+		return nil, errBlockSelf
+	}
+
+	if !req.Block {
+		// language=SQL
+		_, err := pgctx.Exec(ctx, `
+			delete from blocks
+			where user_id = $1 and blocking_id = $2
+		`, userID, blockingID)
+		if err != nil {
+			return nil, err
+		}
+		return new(struct{}), nil
+	}
+
+	err = pgctx.RunInTx(ctx, func(ctx context.Context) error {
+		// language=SQL
+		_, err := pgctx.Exec(ctx, `
+			insert into blocks
+				(user_id, blocking_id)
+			values
+				($1, $2)
+			on conflict (user_id, blocking_id) do nothing
+		`, userID, blockingID)
+		if err != nil {
+			return err
+		}
+
+		// language=SQL
+		_, err = pgctx.Exec(ctx, `
+			delete from follows
+			where (user_id = $1 and following_id = $2)
+			   or (user_id = $2 and following_id = $1)
+		`, userID, blockingID)
+		if err != nil {
+			return err
+		}
+
+		// language=SQL
+		_, err = pgctx.Exec(ctx, `
+			delete from follow_requests
+			where (user_id = $1 and following_id = $2)
+			   or (user_id = $2 and following_id = $1)
+		`, userID, blockingID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = feed.Unfollow(ctx, userID, blockingID)
+	if err != nil {
+		return nil, err
+	}
+	err = feed.Unfollow(ctx, blockingID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(struct{}), nil
+}
+
+type MuteRequest struct {
+	Username string `json:"username"`
+	Mute     bool   `json:"mute"`
+}
+
+func (req *MuteRequest) Valid() error {
+	v := validator.New()
+	v.Must(req.Username != "", "username required")
+	v.Must(utf8.RuneCountInString(req.Username) <= 15, "username maximum 15 characters")
+
+	return v.Error()
+}
+
+// Mute mutes or unmutes user,
+// muted user's works and comments will be hidden from feeds and work comments
+func Mute(ctx context.Context, req *MuteRequest) (*struct{}, error) {
+	userID := session.GetUserID(ctx)
+	if userID == "" {
+		return nil, errInvalidCredentials
+	}
+
+	mutingID, err := getUserIDFromUsername(ctx, req.Username)
+	if err == errNotFound {
+		return nil, errUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if userID == mutingID {
+		return nil, errMuteSelf
+	}
+
+	if req.Mute {
+		// language=SQL
+		_, err = pgctx.Exec(ctx, `
+			insert into mutes
+				(user_id, muting_id)
+			values
+				($1, $2)
+			on conflict (user_id, muting_id) do nothing
+		`, userID, mutingID)
+	} else {
+		// language=SQL
+		_, err = pgctx.Exec(ctx, `
+			delete from mutes
+			where user_id = $1 and muting_id = $2
+		`, userID, mutingID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return new(struct{}), nil
+}
+
+type GetRelationUsersRequest struct {
+	Paginate paginate.Paginate `json:"paginate"`
+}
+
+type RelationUserItem struct {
+	Username    string           `json:"username"`
+	DisplayName string           `json:"displayName"`
+	Photo       file.DownloadURL `json:"photo"`
+}
+
+type GetRelationUsersResult struct {
+	List     []*RelationUserItem `json:"list"`
+	Paginate paginate.Paginate   `json:"paginate"`
+}
+
+func GetBlockedUsers(ctx context.Context, req *GetRelationUsersRequest) (*GetRelationUsersResult, error) {
+	userID := session.GetUserID(ctx)
+	if userID == "" {
+		return nil, errInvalidCredentials
+	}
+
+	{
+		err := req.Paginate.CountFrom(func() (cnt int64, err error) {
+			// language=SQL
+			err = pgctx.QueryRow(ctx, `
+				select count(*) from blocks where user_id = $1
+			`, userID).Scan(&cnt)
+			return
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// language=SQL
+	return getRelationUsers(ctx, req, `
+		select u.username, u.display_name, u.photo
+		from blocks b
+			left join users u on b.blocking_id = u.id
+		where b.user_id = $3
+		order by b.created_at desc
+		offset $1 limit $2
+	`, userID)
+}
+
+func GetMutedUsers(ctx context.Context, req *GetRelationUsersRequest) (*GetRelationUsersResult, error) {
+	userID := session.GetUserID(ctx)
+	if userID == "" {
+		return nil, errInvalidCredentials
+	}
+
+	{
+		err := req.Paginate.CountFrom(func() (cnt int64, err error) {
+			// language=SQL
+			err = pgctx.QueryRow(ctx, `
+				select count(*) from mutes where user_id = $1
+			`, userID).Scan(&cnt)
+			return
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// language=SQL
+	return getRelationUsers(ctx, req, `
+		select u.username, u.display_name, u.photo
+		from mutes m
+			left join users u on m.muting_id = u.id
+		where m.user_id = $3
+		order by m.created_at desc
+		offset $1 limit $2
+	`, userID)
+}
+
+func getRelationUsers(ctx context.Context, req *GetRelationUsersRequest, query string, userID string) (*GetRelationUsersResult, error) {
+	rows, err := pgctx.Query(ctx, query, req.Paginate.Offset(), req.Paginate.Limit(), userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var r GetRelationUsersResult
+	r.List = make([]*RelationUserItem, 0)
+	r.Paginate = req.Paginate
+
+	for rows.Next() {
+		var x RelationUserItem
+		err := rows.Scan(&x.Username, &x.DisplayName, &x.Photo)
+		if err != nil {
+			return nil, err
+		}
+		r.List = append(r.List, &x)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
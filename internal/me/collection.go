@@ -0,0 +1,445 @@
+package me
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/acoshift/pgsql/pgctx"
+	"github.com/asaskevich/govalidator"
+	"github.com/lib/pq"
+
+	"github.com/acoshift/pikkanode/internal/file"
+	"github.com/acoshift/pikkanode/internal/paginate"
+	"github.com/acoshift/pikkanode/internal/session"
+	"github.com/acoshift/pikkanode/internal/validator"
+	"github.com/acoshift/pikkanode/internal/visibility"
+)
+
+type CollectionItem struct {
+	ID          string           `json:"id"`
+	Name        string           `json:"name"`
+	Description string           `json:"description"`
+	Cover       file.DownloadURL `json:"cover"`
+	Visibility  string           `json:"visibility"`
+	WorkCount   int64            `json:"workCount"`
+	CreatedAt   time.Time        `json:"createdAt"`
+}
+
+type GetCollectionsRequest struct {
+	Paginate paginate.Paginate `json:"paginate"`
+}
+
+type GetCollectionsResult struct {
+	List     []*CollectionItem `json:"list"`
+	Paginate paginate.Paginate `json:"paginate"`
+}
+
+// GetCollections lists my collections in my order
+func GetCollections(ctx context.Context, req *GetCollectionsRequest) (*GetCollectionsResult, error) {
+	userID := session.GetUserID(ctx)
+	if userID == "" {
+		return nil, errInvalidCredentials
+	}
+
+	var r GetCollectionsResult
+	{
+		err := req.Paginate.CountFrom(func() (cnt int64, err error) {
+			// language=SQL
+			err = pgctx.QueryRow(ctx, `
+				select count(*) from collections where user_id = $1
+			`, userID).Scan(&cnt)
+			return
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	{
+		// language=SQL
+		rows, err := pgctx.Query(ctx, `
+			select
+				c.id, c.name, c.description, collection_cover(c, $3), c.visibility,
+				(select count(*) from collection_works where collection_id = c.id),
+				c.created_at
+			from collections c
+			where c.user_id = $3
+			order by c.position, c.id
+			offset $1 limit $2
+		`, req.Paginate.Offset(), req.Paginate.Limit(), userID)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		r.List = make([]*CollectionItem, 0)
+		r.Paginate = req.Paginate
+
+		for rows.Next() {
+			var x CollectionItem
+			err := rows.Scan(
+				&x.ID, &x.Name, &x.Description, &x.Cover, &x.Visibility,
+				&x.WorkCount,
+				&x.CreatedAt,
+			)
+			if err != nil {
+				return nil, err
+			}
+			r.List = append(r.List, &x)
+		}
+
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+
+		rows.Close()
+	}
+
+	return &r, nil
+}
+
+type CreateCollectionRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Visibility  string `json:"visibility"`
+}
+
+func (req *CreateCollectionRequest) Valid() error {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Visibility == "" {
+		req.Visibility = visibility.Public
+	}
+
+	v := validator.New()
+	v.Must(req.Name != "", "name required")
+	v.Must(utf8.RuneCountInString(req.Name) <= 100, "name maximum 100 characters")
+	v.Must(utf8.RuneCountInString(req.Description) <= 1000, "description maximum 1000 characters")
+	v.Must(visibility.Valid(req.Visibility), "invalid visibility")
+
+	return v.Error()
+}
+
+type CreateCollectionResult struct {
+	ID string `json:"id"`
+}
+
+// CreateCollection creates new collection at the end of my collections
+func CreateCollection(ctx context.Context, req *CreateCollectionRequest) (*CreateCollectionResult, error) {
+	userID := session.GetUserID(ctx)
+	if userID == "" {
+		return nil, errInvalidCredentials
+	}
+
+	var r CreateCollectionResult
+	err := pgctx.RunInTx(ctx, func(ctx context.Context) error {
+		err := lockUser(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		// language=SQL
+		return pgctx.QueryRow(ctx, `
+			insert into collections
+				(user_id, name, description, visibility, position)
+			values
+				($1, $2, $3, $4, coalesce((select max(position) + 1 from collections where user_id = $1), 0))
+			returning id
+		`, userID, req.Name, req.Description, req.Visibility).Scan(&r.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+type UpdateCollectionRequest struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Visibility  string `json:"visibility"`
+	CoverWorkID string `json:"coverWorkId"` // empty to use the first work
+}
+
+func (req *UpdateCollectionRequest) Valid() error {
+	req.Name = strings.TrimSpace(req.Name)
+
+	v := validator.New()
+	v.Must(req.ID != "", "id required")
+	v.Must(govalidator.IsNumeric(req.ID), "invalid id")
+	v.Must(req.Name != "", "name required")
+	v.Must(utf8.RuneCountInString(req.Name) <= 100, "name maximum 100 characters")
+	v.Must(utf8.RuneCountInString(req.Description) <= 1000, "description maximum 1000 characters")
+	v.Must(visibility.Valid(req.Visibility), "invalid visibility")
+	v.Must(req.CoverWorkID == "" || govalidator.IsNumeric(req.CoverWorkID), "invalid cover work id")
+
+	return v.Error()
+}
+
+func UpdateCollection(ctx context.Context, req *UpdateCollectionRequest) (*struct{}, error) {
+	userID := session.GetUserID(ctx)
+	if userID == "" {
+		return nil, errInvalidCredentials
+	}
+
+	// cover must be a work in collection
+	// language=SQL
+	res, err := pgctx.Exec(ctx, `
+		update collections
+		set
+			name = $3,
+			description = $4,
+			visibility = $5,
+			cover_work_id = (
+				select work_id
+				from collection_works
+				where collection_id = $1 and work_id = nullif($6, '')::bigint
+			)
+		where id = $1 and user_id = $2
+	`, req.ID, userID, req.Name, req.Description, req.Visibility, req.CoverWorkID)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, errCollectionNotFound
+	}
+
+	return new(struct{}), nil
+}
+
+type DeleteCollectionRequest struct {
+	ID string `json:"id"`
+}
+
+func (req *DeleteCollectionRequest) Valid() error {
+	v := validator.New()
+	v.Must(req.ID != "", "id required")
+	v.Must(govalidator.IsNumeric(req.ID), "invalid id")
+
+	return v.Error()
+}
+
+func DeleteCollection(ctx context.Context, req *DeleteCollectionRequest) (*struct{}, error) {
+	userID := session.GetUserID(ctx)
+	if userID == "" {
+		return nil, errInvalidCredentials
+	}
+
+	// language=SQL
+	_, err := pgctx.Exec(ctx, `
+		delete from collections where id = $1 and user_id = $2
+	`, req.ID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(struct{}), nil
+}
+
+type ReorderCollectionsRequest struct {
+	IDs []string `json:"ids"`
+}
+
+func (req *ReorderCollectionsRequest) Valid() error {
+	v := validator.New()
+	v.Must(len(req.IDs) > 0, "ids required")
+	v.Must(len(req.IDs) <= 1000, "ids maximum 1000 items")
+	seen := make(map[string]bool)
+	for i, id := range req.IDs {
+		v.Must(govalidator.IsNumeric(id), fmt.Sprintf("ids[%d] is not valid id", i))
+		v.Must(!seen[id], fmt.Sprintf("ids[%d] is duplicated", i))
+		seen[id] = true
+	}
+
+	return v.Error()
+}
+
+// ReorderCollections sets my collections order,
+// collections not in ids are placed after listed collections in their current order
+func ReorderCollections(ctx context.Context, req *ReorderCollectionsRequest) (*struct{}, error) {
+	userID := session.GetUserID(ctx)
+	if userID == "" {
+		return nil, errInvalidCredentials
+	}
+
+	err := pgctx.RunInTx(ctx, func(ctx context.Context) error {
+		err := lockUser(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		// language=SQL
+		_, err = pgctx.Exec(ctx, `
+			update collections c
+			set position = t.position
+			from (
+				select
+					c.id,
+					row_number() over (order by l.ord nulls last, c.position, c.id) - 1 as position
+				from collections c
+					left join unnest($2::bigint[]) with ordinality as l (id, ord) on c.id = l.id
+				where c.user_id = $1
+			) t
+			where c.id = t.id and c.position != t.position
+		`, userID, pq.Array(req.IDs))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return new(struct{}), nil
+}
+
+type CollectionWorkRequest struct {
+	ID     string `json:"id"`
+	WorkID string `json:"workId"`
+}
+
+func (req *CollectionWorkRequest) Valid() error {
+	v := validator.New()
+	v.Must(req.ID != "", "id required")
+	v.Must(govalidator.IsNumeric(req.ID), "invalid id")
+	v.Must(req.WorkID != "", "work id required")
+	v.Must(govalidator.IsNumeric(req.WorkID), "invalid work id")
+
+	return v.Error()
+}
+
+// AddCollectionWork adds work to the end of collection,
+// work can be any work that I can see
+func AddCollectionWork(ctx context.Context, req *CollectionWorkRequest) (*struct{}, error) {
+	userID := session.GetUserID(ctx)
+	if userID == "" {
+		return nil, errInvalidCredentials
+	}
+
+	err := pgctx.RunInTx(ctx, func(ctx context.Context) error {
+		err := lockCollection(ctx, req.ID, userID)
+		if err != nil {
+			return err
+		}
+
+		// language=SQL
+		res, err := pgctx.Exec(ctx, `
+			insert into collection_works
+				(collection_id, work_id, position)
+			select
+				$1, w.id,
+				coalesce((select max(position) + 1 from collection_works where collection_id = $1), 0)
+			from works w
+			where w.id = $2
+			  and can_view_work(w.user_id, w.visibility, w.status, $3)
+			on conflict (collection_id, work_id) do nothing
+		`, req.ID, req.WorkID, userID)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			return nil
+		}
+
+		// work not found, or already in collection
+		isExists := false
+		// language=SQL
+		err = pgctx.QueryRow(ctx, `
+			select exists(select 1 from collection_works where collection_id = $1 and work_id = $2)
+		`, req.ID, req.WorkID).Scan(&isExists)
+		if err != nil {
+			return err
+		}
+		if !isExists {
+			return errWorkNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return new(struct{}), nil
+}
+
+// RemoveCollectionWork removes work from collection
+func RemoveCollectionWork(ctx context.Context, req *CollectionWorkRequest) (*struct{}, error) {
+	userID := session.GetUserID(ctx)
+	if userID == "" {
+		return nil, errInvalidCredentials
+	}
+
+	err := checkCollectionOwner(ctx, req.ID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// language=SQL
+	_, err = pgctx.Exec(ctx, `
+		delete from collection_works where collection_id = $1 and work_id = $2
+	`, req.ID, req.WorkID)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(struct{}), nil
+}
+
+type ReorderCollectionWorksRequest struct {
+	ID      string   `json:"id"`
+	WorkIDs []string `json:"workIds"`
+}
+
+func (req *ReorderCollectionWorksRequest) Valid() error {
+	v := validator.New()
+	v.Must(req.ID != "", "id required")
+	v.Must(govalidator.IsNumeric(req.ID), "invalid id")
+	v.Must(len(req.WorkIDs) > 0, "work ids required")
+	v.Must(len(req.WorkIDs) <= 1000, "work ids maximum 1000 items")
+	seen := make(map[string]bool)
+	for i, id := range req.WorkIDs {
+		v.Must(govalidator.IsNumeric(id), fmt.Sprintf("workIds[%d] is not valid id", i))
+		v.Must(!seen[id], fmt.Sprintf("workIds[%d] is duplicated", i))
+		seen[id] = true
+	}
+
+	return v.Error()
+}
+
+// ReorderCollectionWorks sets works order in collection,
+// works not in work ids are placed after listed works in their current order
+func ReorderCollectionWorks(ctx context.Context, req *ReorderCollectionWorksRequest) (*struct{}, error) {
+	userID := session.GetUserID(ctx)
+	if userID == "" {
+		return nil, errInvalidCredentials
+	}
+
+	err := pgctx.RunInTx(ctx, func(ctx context.Context) error {
+		err := lockCollection(ctx, req.ID, userID)
+		if err != nil {
+			return err
+		}
+
+		// language=SQL
+		_, err = pgctx.Exec(ctx, `
+			update collection_works cw
+			set position = t.position
+			from (
+				select
+					cw.work_id,
+					row_number() over (order by l.ord nulls last, cw.position, cw.created_at) - 1 as position
+				from collection_works cw
+					left join unnest($2::bigint[]) with ordinality as l (work_id, ord) on cw.work_id = l.work_id
+				where cw.collection_id = $1
+			) t
+			where cw.collection_id = $1 and cw.work_id = t.work_id and cw.position != t.position
+		`, req.ID, pq.Array(req.WorkIDs))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return new(struct{}), nil
+}
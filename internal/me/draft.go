@@ -0,0 +1,172 @@
+package me
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/acoshift/pgsql/pgctx"
+	"github.com/asaskevich/govalidator"
+	"github.com/lib/pq"
+
+	"github.com/acoshift/pikkanode/internal/feed"
+	"github.com/acoshift/pikkanode/internal/mention"
+	"github.com/acoshift/pikkanode/internal/paginate"
+	"github.com/acoshift/pikkanode/internal/publish"
+	"github.com/acoshift/pikkanode/internal/session"
+	"github.com/acoshift/pikkanode/internal/validator"
+)
+
+type GetDraftsRequest struct {
+	Paginate paginate.Paginate `json:"paginate"`
+}
+
+type GetDraftsResult struct {
+	List     []*MyWorkItem     `json:"list"`
+	Paginate paginate.Paginate `json:"paginate"`
+}
+
+// GetDrafts lists draft and scheduled works
+func GetDrafts(ctx context.Context, req *GetDraftsRequest) (*GetDraftsResult, error) {
+	userID := session.GetUserID(ctx)
+	if userID == "" {
+		return nil, errInvalidCredentials
+	}
+
+	var r GetDraftsResult
+	{
+		err := req.Paginate.CountFrom(func() (cnt int64, err error) {
+			// language=SQL
+			err = pgctx.QueryRow(ctx, `
+				select count(*) from works where user_id = $1 and status != $2
+			`, userID, publish.Published).Scan(&cnt)
+			return
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	{
+		// language=SQL
+		rows, err := pgctx.Query(ctx, `
+			select
+				id, name, detail, photo, tags, visibility, status, publish_at, pin_order is not null,
+				favorite_count, comment_count, created_at
+			from works
+			where user_id = $3 and status != $4
+			order by created_at desc
+			offset $1 limit $2
+		`, req.Paginate.Offset(), req.Paginate.Limit(), userID, publish.Published)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		r.List = make([]*MyWorkItem, 0)
+		r.Paginate = req.Paginate
+
+		for rows.Next() {
+			var x MyWorkItem
+			err := rows.Scan(
+				&x.ID, &x.Name, &x.Detail, &x.Photo, pq.Array(&x.Tags), &x.Visibility, &x.Status, &x.PublishAt, &x.IsPinned,
+				&x.FavoriteCount, &x.CommentCount, &x.CreatedAt,
+			)
+			if err != nil {
+				return nil, err
+			}
+			r.List = append(r.List, &x)
+		}
+
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+
+		rows.Close()
+	}
+
+	return &r, nil
+}
+
+type PublishWorkRequest struct {
+	ID        string     `json:"id"`
+	PublishAt *time.Time `json:"publishAt"` // null to publish now
+}
+
+func (req *PublishWorkRequest) Valid() error {
+	v := validator.New()
+	v.Must(req.ID != "", "id required")
+	v.Must(govalidator.IsNumeric(req.ID), "invalid id")
+
+	return v.Error()
+}
+
+type PublishWorkResult struct {
+	Status    string    `json:"status"`
+	PublishAt time.Time `json:"publishAt"`
+}
+
+// PublishWork publishes draft or scheduled work now,
+// or schedules (reschedules) it when publish time is in the future
+func PublishWork(ctx context.Context, req *PublishWorkRequest) (*PublishWorkResult, error) {
+	userID := session.GetUserID(ctx)
+	if userID == "" {
+		return nil, errInvalidCredentials
+	}
+
+	var r PublishWorkResult
+	r.Status = publish.Published
+	r.PublishAt = time.Now()
+	if req.PublishAt != nil && req.PublishAt.After(r.PublishAt) {
+		r.Status = publish.Scheduled
+		r.PublishAt = *req.PublishAt
+	}
+
+	var status string
+	// language=SQL
+	err := pgctx.QueryRow(ctx, `
+		select status
+		from works
+		where id = $1 and user_id = $2
+	`, req.ID, userID).Scan(&status)
+	if err == sql.ErrNoRows {
+		return nil, errWorkNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if status == publish.Published {
+		return nil, errWorkAlreadyPublished
+	}
+
+	// language=SQL
+	res, err := pgctx.Exec(ctx, `
+		update works
+		set status = $3,
+		    publish_at = $4
+		where id = $1 and user_id = $2 and status != $5
+	`, req.ID, userID, r.Status, r.PublishAt, publish.Published)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		// published by scheduler
+		return nil, errWorkAlreadyPublished
+	}
+
+	if r.Status == publish.Published {
+		id, _ := strconv.ParseInt(req.ID, 10, 64)
+		err = feed.AddWork(ctx, userID, id, r.PublishAt)
+		if err != nil {
+			return nil, err
+		}
+
+		err = mention.NotifyWork(ctx, req.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &r, nil
+}
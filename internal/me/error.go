@@ -5,6 +5,17 @@ import (
 )
 
 var (
-	errInvalidCredentials = arpc.NewError("invalid credentials")
-	errWorkNotFound       = arpc.NewError("photo not found")
+	errInvalidCredentials     = arpc.NewError("invalid credentials")
+	errWorkNotFound           = arpc.NewError("photo not found")
+	errPinLimitExceeded       = arpc.NewError("pinned works limit exceeded")
+	errWorkAlreadyPublished   = arpc.NewError("work already published")
+	errInvalidPassword        = arpc.NewError("invalid password")
+	errInviteQuotaExceeded    = arpc.NewError("invite quota exceeded")
+	errUsernameNotAvailable   = arpc.NewError("username not available")
+	errUsernameChangeCooldown = arpc.NewError("username recently changed")
+	errUserNotFound           = arpc.NewError("user not found")
+	errBlockSelf              = arpc.NewError("can not block self")
+	errCollectionNotFound     = arpc.NewError("collection not found")
+	errFollowRequestNotFound  = arpc.NewError("follow request not found")
+	errMuteSelf               = arpc.NewError("can not mute self")
 )
@@ -0,0 +1,401 @@
+package me
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/acoshift/pgsql/pgctx"
+	"github.com/asaskevich/govalidator"
+	"github.com/lib/pq"
+
+	"github.com/acoshift/pikkanode/internal/config"
+	"github.com/acoshift/pikkanode/internal/file"
+	"github.com/acoshift/pikkanode/internal/session"
+)
+
+type ExportDataResult struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"` // download with session cookie
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// ExportData exports all user's data into zip file,
+// the file can be downloaded only by the user until expired
+func ExportData(ctx context.Context, _ *struct{}) (*ExportDataResult, error) {
+	userID := session.GetUserID(ctx)
+	if userID == "" {
+		return nil, errInvalidCredentials
+	}
+
+	fn := file.GeneratePrivateFilename("zip")
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeExport(ctx, pw, userID))
+	}()
+
+	err := file.Store(ctx, file.File{
+		Reader:      pr,
+		Name:        fn,
+		ContentType: "application/zip",
+	})
+	pr.CloseWithError(err)
+	if err != nil {
+		return nil, err
+	}
+
+	var r ExportDataResult
+	r.ExpiresAt = time.Now().Add(config.DataExportExpiry())
+	r.ID, err = insertDataExport(ctx, userID, fn, r.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	r.URL = config.BaseURL() + "/me/downloadDataExport?id=" + r.ID
+
+	return &r, nil
+}
+
+// DownloadDataExportHandler serves user's data export file,
+// only session cookie is allowed
+func DownloadDataExportHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		userID := session.GetUserID(ctx)
+		if userID == "" {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		id := r.FormValue("id")
+		if !govalidator.IsUUID(id) {
+			http.NotFound(w, r)
+			return
+		}
+
+		fn, err := getDataExportFile(ctx, userID, id)
+		if err == sql.ErrNoRows {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Disposition", `attachment; filename="pikkanode-export.zip"`)
+		w.Header().Set("Cache-Control", "private, no-store")
+		err = file.Serve(ctx, w, fn)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+	})
+}
+
+type exportProfile struct {
+	Username    string    `json:"username"`
+	DisplayName string    `json:"displayName"`
+	Bio         string    `json:"bio"`
+	Links       []string  `json:"links"`
+	Location    string    `json:"location"`
+	Pronouns    string    `json:"pronouns"`
+	Photo       string    `json:"photo"`
+	Banner      string    `json:"banner"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+type exportWork struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Detail     string     `json:"detail"`
+	Photo      string     `json:"photo"`
+	Tags       []string   `json:"tags"`
+	Visibility string     `json:"visibility"`
+	Status     string     `json:"status"`
+	PublishAt  *time.Time `json:"publishAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+type exportComment struct {
+	ID        string    `json:"id"`
+	WorkID    string    `json:"workId"`
+	ParentID  *string   `json:"parentId"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type exportFavorite struct {
+	WorkID    string    `json:"workId"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type exportFollow struct {
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// exportReadme describes export files, included in export as README.txt
+const exportReadme = `pikkanode data export
+
+profile.json    your profile
+works.json      your works, photo is the path of the photo in this export
+comments.json   your comments
+favorites.json  works you favorited
+following.json  users you follow
+followers.json  users following you
+works/          original photos of your works, as you uploaded them
+
+Works uploaded before original files were kept have the photo shown on pikkanode,
+which was re-encoded and had its metadata (ex. location) removed.
+`
+
+func writeExport(ctx context.Context, w io.Writer, userID string) error {
+	zw := zip.NewWriter(w)
+
+	var (
+		profile  exportProfile
+		works    []*exportWork
+		photos   = make(map[string]string) // zip name => stored file
+		comments []*exportComment
+		favs     []*exportFavorite
+	)
+
+	// language=SQL
+	err := pgctx.QueryRow(ctx, `
+		select
+			username, display_name, bio, links, location, pronouns,
+			photo, banner, created_at
+		from users
+		where id = $1
+	`, userID).Scan(
+		&profile.Username, &profile.DisplayName, &profile.Bio, pq.Array(&profile.Links), &profile.Location, &profile.Pronouns,
+		&profile.Photo, &profile.Banner, &profile.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	if profile.Photo != "" {
+		fn := "profile" + path.Ext(profile.Photo)
+		photos[fn] = profile.Photo
+		profile.Photo = fn
+	}
+	if profile.Banner != "" {
+		fn := "banner" + path.Ext(profile.Banner)
+		photos[fn] = profile.Banner
+		profile.Banner = fn
+	}
+
+	{
+		// language=SQL
+		rows, err := pgctx.Query(ctx, `
+			select id, name, detail, photo, original, tags, visibility, status, publish_at, created_at
+			from works
+			where user_id = $1
+			order by created_at
+		`, userID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		works = make([]*exportWork, 0)
+		for rows.Next() {
+			var (
+				x        exportWork
+				original string
+			)
+			err := rows.Scan(&x.ID, &x.Name, &x.Detail, &x.Photo, &original, pq.Array(&x.Tags), &x.Visibility, &x.Status, &x.PublishAt, &x.CreatedAt)
+			if err != nil {
+				return err
+			}
+			if original != "" {
+				x.Photo = original
+			}
+			fn := path.Join("works", x.ID+path.Ext(x.Photo))
+			photos[fn] = x.Photo
+			x.Photo = fn
+			works = append(works, &x)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		rows.Close()
+	}
+
+	{
+		// language=SQL
+		rows, err := pgctx.Query(ctx, `
+			select id, work_id, parent_id, content, created_at
+			from comments
+			where user_id = $1 and deleted_at is null
+			order by created_at
+		`, userID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		comments = make([]*exportComment, 0)
+		for rows.Next() {
+			var x exportComment
+			err := rows.Scan(&x.ID, &x.WorkID, &x.ParentID, &x.Content, &x.CreatedAt)
+			if err != nil {
+				return err
+			}
+			comments = append(comments, &x)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		rows.Close()
+	}
+
+	{
+		// language=SQL
+		rows, err := pgctx.Query(ctx, `
+			select work_id, created_at
+			from favorites
+			where user_id = $1
+			order by created_at
+		`, userID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		favs = make([]*exportFavorite, 0)
+		for rows.Next() {
+			var x exportFavorite
+			err := rows.Scan(&x.WorkID, &x.CreatedAt)
+			if err != nil {
+				return err
+			}
+			favs = append(favs, &x)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		rows.Close()
+	}
+
+	// language=SQL
+	following, err := exportFollows(ctx, `
+		select u.username, f.created_at
+		from follows f
+			left join users u on f.following_id = u.id
+		where f.user_id = $1
+		order by f.created_at
+	`, userID)
+	if err != nil {
+		return err
+	}
+
+	// language=SQL
+	followers, err := exportFollows(ctx, `
+		select u.username, f.created_at
+		from follows f
+			left join users u on f.user_id = u.id
+		where f.following_id = $1
+		order by f.created_at
+	`, userID)
+	if err != nil {
+		return err
+	}
+
+	{
+		fw, err := zw.Create("README.txt")
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(fw, exportReadme)
+		if err != nil {
+			return err
+		}
+	}
+
+	jsonFiles := []struct {
+		Name string
+		Data interface{}
+	}{
+		{"profile.json", profile},
+		{"works.json", works},
+		{"comments.json", comments},
+		{"favorites.json", favs},
+		{"following.json", following},
+		{"followers.json", followers},
+	}
+	for _, x := range jsonFiles {
+		fw, err := zw.Create(x.Name)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(x.Data)
+		if err != nil {
+			return err
+		}
+	}
+
+	for name, fn := range photos {
+		err := exportFile(ctx, zw, name, fn)
+		if err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+func exportFollows(ctx context.Context, query string, userID string) ([]*exportFollow, error) {
+	rows, err := pgctx.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	xs := make([]*exportFollow, 0)
+	for rows.Next() {
+		var x exportFollow
+		err := rows.Scan(&x.Username, &x.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		xs = append(xs, &x)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return xs, nil
+}
+
+func exportFile(ctx context.Context, zw *zip.Writer, name, filename string) error {
+	rd, err := file.Open(ctx, filename)
+	if err != nil {
+		return err
+	}
+	defer rd.Close()
+
+	// images already compressed
+	fw, err := zw.CreateHeader(&zip.FileHeader{
+		Name:   name,
+		Method: zip.Store,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(fw, rd)
+	return err
+}
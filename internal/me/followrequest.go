@@ -0,0 +1,246 @@
+package me
+
+import (
+	"context"
+	"time"
+	"unicode/utf8"
+
+	"github.com/acoshift/pgsql/pgctx"
+
+	"github.com/acoshift/pikkanode/internal/feed"
+	"github.com/acoshift/pikkanode/internal/file"
+	"github.com/acoshift/pikkanode/internal/paginate"
+	"github.com/acoshift/pikkanode/internal/session"
+	"github.com/acoshift/pikkanode/internal/validator"
+)
+
+type SetPrivateRequest struct {
+	Private bool `json:"private"`
+}
+
+// SetPrivate sets account privacy,
+// pending follow requests will be approved when account becomes public
+func SetPrivate(ctx context.Context, req *SetPrivateRequest) (*struct{}, error) {
+	userID := session.GetUserID(ctx)
+	if userID == "" {
+		return nil, errInvalidCredentials
+	}
+
+	var approvedIDs []string
+	err := pgctx.RunInTx(ctx, func(ctx context.Context) error {
+		approvedIDs = nil
+
+		// language=SQL
+		_, err := pgctx.Exec(ctx, `
+			update users
+			set is_private = $2
+			where id = $1
+		`, userID, req.Private)
+		if err != nil {
+			return err
+		}
+
+		if req.Private {
+			return nil
+		}
+
+		// language=SQL
+		rows, err := pgctx.Query(ctx, `
+			with r as (
+				delete from follow_requests
+				where following_id = $1
+				returning user_id, following_id
+			)
+			insert into follows
+				(user_id, following_id)
+			select user_id, following_id
+			from r
+			on conflict (user_id, following_id) do nothing
+			returning user_id
+		`, userID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var id string
+			err := rows.Scan(&id)
+			if err != nil {
+				return err
+			}
+			approvedIDs = append(approvedIDs, id)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range approvedIDs {
+		err := feed.Follow(ctx, id, userID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return new(struct{}), nil
+}
+
+type GetFollowRequestsRequest struct {
+	Paginate paginate.Paginate `json:"paginate"`
+}
+
+type FollowRequestItem struct {
+	Username    string           `json:"username"`
+	DisplayName string           `json:"displayName"`
+	Photo       file.DownloadURL `json:"photo"`
+	CreatedAt   time.Time        `json:"createdAt"`
+}
+
+type GetFollowRequestsResult struct {
+	List     []*FollowRequestItem `json:"list"`
+	Paginate paginate.Paginate    `json:"paginate"`
+}
+
+// GetFollowRequests lists pending follow requests, newest first
+func GetFollowRequests(ctx context.Context, req *GetFollowRequestsRequest) (*GetFollowRequestsResult, error) {
+	userID := session.GetUserID(ctx)
+	if userID == "" {
+		return nil, errInvalidCredentials
+	}
+
+	var r GetFollowRequestsResult
+	{
+		err := req.Paginate.CountFrom(func() (cnt int64, err error) {
+			// language=SQL
+			err = pgctx.QueryRow(ctx, `
+				select count(*) from follow_requests where following_id = $1
+			`, userID).Scan(&cnt)
+			return
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	{
+		// language=SQL
+		rows, err := pgctx.Query(ctx, `
+			select u.username, u.display_name, u.photo, r.created_at
+			from follow_requests r
+				left join users u on r.user_id = u.id
+			where r.following_id = $3
+			order by r.created_at desc
+			offset $1 limit $2
+		`, req.Paginate.Offset(), req.Paginate.Limit(), userID)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		r.List = make([]*FollowRequestItem, 0)
+		r.Paginate = req.Paginate
+
+		for rows.Next() {
+			var x FollowRequestItem
+			err := rows.Scan(&x.Username, &x.DisplayName, &x.Photo, &x.CreatedAt)
+			if err != nil {
+				return nil, err
+			}
+			r.List = append(r.List, &x)
+		}
+
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+
+		rows.Close()
+	}
+
+	return &r, nil
+}
+
+type FollowRequestRequest struct {
+	Username string `json:"username"`
+}
+
+func (req *FollowRequestRequest) Valid() error {
+	v := validator.New()
+	v.Must(req.Username != "", "username required")
+	v.Must(utf8.RuneCountInString(req.Username) <= 15, "username maximum 15 characters")
+
+	return v.Error()
+}
+
+// ApproveFollowRequest approves user's follow request
+func ApproveFollowRequest(ctx context.Context, req *FollowRequestRequest) (*struct{}, error) {
+	userID := session.GetUserID(ctx)
+	if userID == "" {
+		return nil, errInvalidCredentials
+	}
+
+	requesterID, err := getUserIDFromUsername(ctx, req.Username)
+	if err == errNotFound {
+		return nil, errFollowRequestNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = pgctx.RunInTx(ctx, func(ctx context.Context) error {
+		found, err := deleteFollowRequest(ctx, requesterID, userID)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return errFollowRequestNotFound
+		}
+
+		// language=SQL
+		_, err = pgctx.Exec(ctx, `
+			insert into follows
+				(user_id, following_id)
+			values
+				($1, $2)
+			on conflict (user_id, following_id) do nothing
+		`, requesterID, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = feed.Follow(ctx, requesterID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(struct{}), nil
+}
+
+// RejectFollowRequest rejects user's follow request
+func RejectFollowRequest(ctx context.Context, req *FollowRequestRequest) (*struct{}, error) {
+	userID := session.GetUserID(ctx)
+	if userID == "" {
+		return nil, errInvalidCredentials
+	}
+
+	requesterID, err := getUserIDFromUsername(ctx, req.Username)
+	if err == errNotFound {
+		return nil, errFollowRequestNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	found, err := deleteFollowRequest(ctx, requesterID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errFollowRequestNotFound
+	}
+
+	return new(struct{}), nil
+}
@@ -0,0 +1,111 @@
+package me
+
+import (
+	"context"
+	"time"
+
+	"github.com/acoshift/pgsql/pgctx"
+	"github.com/lib/pq"
+
+	"github.com/acoshift/pikkanode/internal/config"
+	"github.com/acoshift/pikkanode/internal/invite"
+	"github.com/acoshift/pikkanode/internal/session"
+)
+
+type CreateInviteResult struct {
+	Code      string    `json:"code"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+func CreateInvite(ctx context.Context, _ *struct{}) (*CreateInviteResult, error) {
+	userID := session.GetUserID(ctx)
+	if userID == "" {
+		return nil, errInvalidCredentials
+	}
+
+	var r CreateInviteResult
+	err := pgctx.RunInTx(ctx, func(ctx context.Context) error {
+		// lock user, concurrent requests can not pass quota check together
+		err := lockUser(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		var cnt int
+		// language=SQL
+		err = pgctx.QueryRow(ctx, `
+			select count(*) from invites where user_id = $1
+		`, userID).Scan(&cnt)
+		if err != nil {
+			return err
+		}
+		if cnt >= config.InviteQuota() {
+			return errInviteQuotaExceeded
+		}
+
+		r.ExpiresAt = time.Now().Add(config.InviteExpiry())
+		r.Code, err = invite.Create(ctx, userID, 1, r.ExpiresAt)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+type InviteItem struct {
+	Code      string    `json:"code"`
+	MaxUses   int       `json:"maxUses"`
+	Uses      int       `json:"uses"`
+	UsedBy    []string  `json:"usedBy"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type GetInvitesResult struct {
+	List  []*InviteItem `json:"list"`
+	Quota int           `json:"quota"`
+}
+
+func GetInvites(ctx context.Context, _ *struct{}) (*GetInvitesResult, error) {
+	userID := session.GetUserID(ctx)
+	if userID == "" {
+		return nil, errInvalidCredentials
+	}
+
+	// language=SQL
+	rows, err := pgctx.Query(ctx, `
+		select
+			i.code, i.max_uses, i.uses, i.expires_at, i.created_at,
+			array(select username from users where invite_code = i.code order by created_at)
+		from invites i
+		where i.user_id = $1
+		order by i.created_at desc
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var r GetInvitesResult
+	r.Quota = config.InviteQuota()
+	r.List = make([]*InviteItem, 0)
+	for rows.Next() {
+		var x InviteItem
+		err := rows.Scan(
+			&x.Code, &x.MaxUses, &x.Uses, &x.ExpiresAt, &x.CreatedAt,
+			pq.Array(&x.UsedBy),
+		)
+		if err != nil {
+			return nil, err
+		}
+		r.List = append(r.List, &x)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
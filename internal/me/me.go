@@ -4,13 +4,17 @@ import (
 	"bytes"
 	"context"
 	"database/sql"
+	"fmt"
 	"io/ioutil"
 	"mime/multipart"
 	"net/http"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/acoshift/arpc"
 	"github.com/acoshift/pgsql/pgctx"
+	"github.com/lib/pq"
 
 	"github.com/acoshift/pikkanode/internal/file"
 	"github.com/acoshift/pikkanode/internal/image"
@@ -29,8 +33,19 @@ func (*ProfileRequest) AdaptRequest(r *http.Request) {
 }
 
 type ProfileResult struct {
-	Username string           `json:"username"`
-	Photo    file.DownloadURL `json:"photo"`
+	Username       string           `json:"username"`
+	DisplayName    string           `json:"displayName"`
+	Bio            string           `json:"bio"`
+	Links          []string         `json:"links"`
+	Location       string           `json:"location"`
+	Pronouns       string           `json:"pronouns"`
+	Photo          file.DownloadURL `json:"photo"`
+	Banner         file.DownloadURL `json:"banner"`
+	IsPrivate      bool             `json:"isPrivate"`
+	FollowerCount  int64            `json:"followerCount"`
+	FollowingCount int64            `json:"followingCount"`
+	WorkCount      int64            `json:"workCount"`
+	DeleteAt       *time.Time       `json:"deleteAt"`
 }
 
 func Profile(ctx context.Context, _ *ProfileRequest) (*ProfileResult, error) {
@@ -42,11 +57,20 @@ func Profile(ctx context.Context, _ *ProfileRequest) (*ProfileResult, error) {
 	var r ProfileResult
 	// language=SQL
 	err := pgctx.QueryRow(ctx, `
-		select username, photo
+		select
+			username, display_name, bio, links, location, pronouns,
+			photo, banner, is_private,
+			(select count(*) from follows where following_id = users.id),
+			(select count(*) from follows where user_id = users.id),
+			(select count(*) from works where user_id = users.id),
+			delete_at
 		from users
 		where id = $1
 	`, userID).Scan(
-		&r.Username, &r.Photo,
+		&r.Username, &r.DisplayName, &r.Bio, pq.Array(&r.Links), &r.Location, &r.Pronouns,
+		&r.Photo, &r.Banner, &r.IsPrivate,
+		&r.FollowerCount, &r.FollowingCount, &r.WorkCount,
+		&r.DeleteAt,
 	)
 	if err == sql.ErrNoRows {
 		// user removed ?
@@ -123,3 +147,119 @@ func UploadProfilePhoto(ctx context.Context, req *UploadProfilePhotoRequest) (*s
 
 	return new(struct{}), nil
 }
+
+type UpdateProfileRequest struct {
+	DisplayName string   `json:"displayName"`
+	Bio         string   `json:"bio"`
+	Links       []string `json:"links"`
+	Location    string   `json:"location"`
+	Pronouns    string   `json:"pronouns"`
+}
+
+func (req *UpdateProfileRequest) Valid() error {
+	req.DisplayName = strings.TrimSpace(req.DisplayName)
+	req.Location = strings.TrimSpace(req.Location)
+	req.Pronouns = strings.TrimSpace(req.Pronouns)
+	for i := range req.Links {
+		req.Links[i] = strings.TrimSpace(req.Links[i])
+	}
+
+	v := validator.New()
+	v.Must(utf8.RuneCountInString(req.DisplayName) <= 50, "display name maximum 50 characters")
+	v.Must(utf8.RuneCountInString(req.Bio) <= 500, "bio maximum 500 characters")
+	v.Must(len(req.Links) <= 5, "links maximum 5 items")
+	for i, l := range req.Links {
+		v.Must(utf8.RuneCountInString(l) <= 255, fmt.Sprintf("links[%d] maximum 255 characters", i))
+		v.Must(validator.IsWebURL(l), fmt.Sprintf("links[%d] is not valid url", i))
+	}
+	v.Must(utf8.RuneCountInString(req.Location) <= 100, "location maximum 100 characters")
+	v.Must(utf8.RuneCountInString(req.Pronouns) <= 30, "pronouns maximum 30 characters")
+
+	return v.Error()
+}
+
+func UpdateProfile(ctx context.Context, req *UpdateProfileRequest) (*struct{}, error) {
+	userID := session.GetUserID(ctx)
+	if userID == "" {
+		return nil, errInvalidCredentials
+	}
+
+	req.Links = append([]string{}, req.Links...)
+	err := updateProfile(ctx, &updateProfileParam{
+		UserID:      userID,
+		DisplayName: req.DisplayName,
+		Bio:         req.Bio,
+		Links:       req.Links,
+		Location:    req.Location,
+		Pronouns:    req.Pronouns,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return new(struct{}), nil
+}
+
+type UploadProfileBannerRequest struct {
+	Banner *multipart.FileHeader
+}
+
+func (req *UploadProfileBannerRequest) UnmarshalJSON(_ []byte) error {
+	return arpc.ErrUnsupported
+}
+
+func (req *UploadProfileBannerRequest) UnmarshalMultipartForm(v *multipart.Form) error {
+	fp := v.File["banner"]
+	if len(fp) == 1 {
+		req.Banner = fp[0]
+	}
+
+	return nil
+}
+
+func (req *UploadProfileBannerRequest) Valid() error {
+	v := validator.New()
+	v.Must(req.Banner != nil, "banner required")
+	v.Must(image.Valid(req.Banner), "invalid banner")
+
+	return v.Error()
+}
+
+func UploadProfileBanner(ctx context.Context, req *UploadProfileBannerRequest) (*struct{}, error) {
+	userID := session.GetUserID(ctx)
+	if userID == "" {
+		return nil, errInvalidCredentials
+	}
+
+	ext := image.Ext(req.Banner)
+
+	fp, err := req.Banner.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+
+	var buf bytes.Buffer
+	err = image.Banner(ctx, &buf, fp, ext)
+	if err != nil {
+		return nil, err
+	}
+
+	fn := file.GenerateFilename(ext)
+
+	err = file.Store(ctx, file.File{
+		Reader:      &buf,
+		Name:        fn,
+		ContentType: image.ContentType(ext),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = setUserBanner(ctx, userID, fn)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(struct{}), nil
+}
@@ -2,11 +2,31 @@ package me
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"time"
 
 	"github.com/acoshift/pgsql/pgctx"
 	"github.com/lib/pq"
 )
 
+var (
+	errNotFound = errors.New("me: not found")
+)
+
+func getUserIDFromUsername(ctx context.Context, username string) (userID string, err error) {
+	// language=SQL
+	err = pgctx.QueryRow(ctx, `
+		select id
+		from users
+		where lower(username) = lower($1)
+	`, username).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return "", errNotFound
+	}
+	return
+}
+
 func setUserPhoto(ctx context.Context, userID string, photo string) error {
 	// language=SQL
 	_, err := pgctx.Exec(ctx, `
@@ -17,31 +37,70 @@ func setUserPhoto(ctx context.Context, userID string, photo string) error {
 	return err
 }
 
+func setUserBanner(ctx context.Context, userID string, banner string) error {
+	// language=SQL
+	_, err := pgctx.Exec(ctx, `
+		update users
+		set banner = $2
+		where id = $1
+	`, userID, banner)
+	return err
+}
+
+type updateProfileParam struct {
+	UserID      string
+	DisplayName string
+	Bio         string
+	Links       []string
+	Location    string
+	Pronouns    string
+}
+
+func updateProfile(ctx context.Context, x *updateProfileParam) error {
+	// language=SQL
+	_, err := pgctx.Exec(ctx, `
+		update users
+		set
+			display_name = $2,
+			bio = $3,
+			links = $4,
+			location = $5,
+			pronouns = $6
+		where id = $1
+	`, x.UserID, x.DisplayName, x.Bio, pq.Array(x.Links), x.Location, x.Pronouns)
+	return err
+}
+
 type insertWorkPhotoParam struct {
-	UserID string
-	Name   string
-	Detail string
-	Photo  string
-	Tags   []string
+	UserID     string
+	Name       string
+	Detail     string
+	Photo      string
+	Original   string
+	Tags       []string
+	Visibility string
+	Status     string
+	PublishAt  *time.Time
 }
 
 func insertWorkPhoto(ctx context.Context, x *insertWorkPhotoParam) (id int64, err error) {
 	// language=SQL
 	err = pgctx.QueryRow(ctx, `
 		insert into works
-			(user_id, name, detail, photo, tags)
+			(user_id, name, detail, photo, original, tags, visibility, status, publish_at)
 		values
-			($1, $2, $3, $4, $5)
+			($1, $2, $3, $4, $5, $6, $7, $8, $9)
 		returning id
-	`, x.UserID, x.Name, x.Detail, x.Photo, pq.Array(x.Tags)).Scan(&id)
+	`, x.UserID, x.Name, x.Detail, x.Photo, x.Original, pq.Array(x.Tags), x.Visibility, x.Status, x.PublishAt).Scan(&id)
 	return
 }
 
 type updateWorkParam struct {
-	ID     string
-	Name   string
-	Detail string
-	Tags   []string
+	ID         string
+	Name       string
+	Detail     string
+	Tags       []string
+	Visibility string
 }
 
 func updateWork(ctx context.Context, x *updateWorkParam) error {
@@ -51,8 +110,130 @@ func updateWork(ctx context.Context, x *updateWorkParam) error {
 		set
 			name = $2,
 			detail = $3,
-			tags = $4
+			tags = $4,
+			visibility = coalesce(nullif($5, ''), visibility)
+		where id = $1
+	`, x.ID, x.Name, x.Detail, pq.Array(x.Tags), x.Visibility)
+	return err
+}
+
+func insertAccessToken(ctx context.Context, userID, name, hashedToken string, scopes []string) (id string, err error) {
+	// language=SQL
+	err = pgctx.QueryRow(ctx, `
+		insert into access_tokens
+			(user_id, name, token, scopes)
+		values
+			($1, $2, $3, $4)
+		returning id
+	`, userID, name, hashedToken, pq.Array(scopes)).Scan(&id)
+	return
+}
+
+func getUserPassword(ctx context.Context, userID string) (hashedPassword string, err error) {
+	// language=SQL
+	err = pgctx.QueryRow(ctx, `
+		select password
+		from users
 		where id = $1
-	`, x.ID, x.Name, x.Detail, pq.Array(x.Tags))
+	`, userID).Scan(&hashedPassword)
+	return
+}
+
+func setUserPassword(ctx context.Context, userID, hashedPassword string) error {
+	// language=SQL
+	_, err := pgctx.Exec(ctx, `
+		update users
+		set password = $2
+		where id = $1
+	`, userID, hashedPassword)
+	return err
+}
+
+func deleteAccessTokens(ctx context.Context, userID string) error {
+	// language=SQL
+	_, err := pgctx.Exec(ctx, `
+		delete from access_tokens where user_id = $1
+	`, userID)
+	return err
+}
+
+func insertDataExport(ctx context.Context, userID, filename string, expiresAt time.Time) (id string, err error) {
+	// language=SQL
+	err = pgctx.QueryRow(ctx, `
+		insert into data_exports
+			(user_id, file, expires_at)
+		values
+			($1, $2, $3)
+		returning id
+	`, userID, filename, expiresAt).Scan(&id)
+	return
+}
+
+func getDataExportFile(ctx context.Context, userID, id string) (filename string, err error) {
+	// language=SQL
+	err = pgctx.QueryRow(ctx, `
+		select file
+		from data_exports
+		where id = $1 and user_id = $2 and expires_at > now()
+	`, id, userID).Scan(&filename)
+	return
+}
+
+func deleteFollowRequest(ctx context.Context, userID, followingID string) (found bool, err error) {
+	// language=SQL
+	res, err := pgctx.Exec(ctx, `
+		delete from follow_requests
+		where user_id = $1 and following_id = $2
+	`, userID, followingID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func checkCollectionOwner(ctx context.Context, collectionID, userID string) error {
+	isExists := false
+	// language=SQL
+	err := pgctx.QueryRow(ctx, `
+		select exists(
+			select 1
+			from collections
+			where id = $1 and user_id = $2
+		)
+	`, collectionID, userID).Scan(&isExists)
+	if err != nil {
+		return err
+	}
+	if !isExists {
+		return errCollectionNotFound
+	}
+	return nil
+}
+
+// lockUser locks user row until transaction ends,
+// serializes changes to positions of user's items
+func lockUser(ctx context.Context, userID string) error {
+	var ok bool
+	// language=SQL
+	return pgctx.QueryRow(ctx, `
+		select true from users where id = $1 for update
+	`, userID).Scan(&ok)
+}
+
+// lockCollection locks user's collection until transaction ends,
+// serializes changes to positions of works in collection
+func lockCollection(ctx context.Context, collectionID, userID string) error {
+	var ok bool
+	// language=SQL
+	err := pgctx.QueryRow(ctx, `
+		select true from collections where id = $1 and user_id = $2 for update
+	`, collectionID, userID).Scan(&ok)
+	if err == sql.ErrNoRows {
+		return errCollectionNotFound
+	}
 	return err
 }
@@ -3,7 +3,9 @@ package me
 import (
 	"bytes"
 	"context"
+	"database/sql"
 	"fmt"
+	"io"
 	"mime/multipart"
 	"strconv"
 	"strings"
@@ -15,11 +17,16 @@ import (
 	"github.com/asaskevich/govalidator"
 	"github.com/lib/pq"
 
+	"github.com/acoshift/pikkanode/internal/feed"
 	"github.com/acoshift/pikkanode/internal/file"
 	"github.com/acoshift/pikkanode/internal/image"
+	"github.com/acoshift/pikkanode/internal/mention"
 	"github.com/acoshift/pikkanode/internal/paginate"
+	"github.com/acoshift/pikkanode/internal/publish"
+	"github.com/acoshift/pikkanode/internal/purge"
 	"github.com/acoshift/pikkanode/internal/session"
 	"github.com/acoshift/pikkanode/internal/validator"
+	"github.com/acoshift/pikkanode/internal/visibility"
 )
 
 type RemoveWorkRequest struct {
@@ -40,10 +47,28 @@ func RemoveWork(ctx context.Context, req *RemoveWorkRequest) (*struct{}, error)
 		return nil, errInvalidCredentials
 	}
 
-	// language=SQL
-	_, err := pgctx.Exec(ctx, `
-		delete from works where user_id = $1 and id = $2
-	`, userID, req.ID)
+	err := pgctx.RunInTx(ctx, func(ctx context.Context) error {
+		var photo, original string
+		// language=SQL
+		err := pgctx.QueryRow(ctx, `
+			delete from works
+			where user_id = $1 and id = $2
+			returning photo, original
+		`, userID, req.ID).Scan(&photo, &original)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		return purge.QueueFiles(ctx, photo, original)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = feed.RemoveWork(ctx, userID, req.ID)
 	if err != nil {
 		return nil, err
 	}
@@ -56,12 +81,18 @@ type GetMyWorksRequest struct {
 }
 
 type MyWorkItem struct {
-	ID        string           `json:"id"`
-	Name      string           `json:"name"`
-	Detail    string           `json:"detail"`
-	Photo     file.DownloadURL `json:"photo"`
-	Tags      []string         `json:"tags"`
-	CreatedAt time.Time        `json:"createdAt"`
+	ID            string           `json:"id"`
+	Name          string           `json:"name"`
+	Detail        string           `json:"detail"`
+	Photo         file.DownloadURL `json:"photo"`
+	Tags          []string         `json:"tags"`
+	Visibility    string           `json:"visibility"`
+	Status        string           `json:"status"`
+	PublishAt     *time.Time       `json:"publishAt"`
+	IsPinned      bool             `json:"isPinned"`
+	FavoriteCount int64            `json:"favoriteCount"`
+	CommentCount  int64            `json:"commentCount"`
+	CreatedAt     time.Time        `json:"createdAt"`
 }
 
 type GetMyWorksResult struct {
@@ -93,9 +124,11 @@ func GetMyWorks(ctx context.Context, req *GetMyWorksRequest) (*GetMyWorksResult,
 		// language=SQL
 		rows, err := pgctx.Query(ctx, `
 			select
-				id, name, detail, photo, tags, created_at
+				id, name, detail, photo, tags, visibility, status, publish_at, pin_order is not null,
+				favorite_count, comment_count, created_at
 			from works
 			where user_id = $3
+			order by pin_order nulls last, created_at desc, id desc
 			offset $1 limit $2
 		`, req.Paginate.Offset(), req.Paginate.Limit(), userID)
 		if err != nil {
@@ -109,7 +142,8 @@ func GetMyWorks(ctx context.Context, req *GetMyWorksRequest) (*GetMyWorksResult,
 		for rows.Next() {
 			var x MyWorkItem
 			err := rows.Scan(
-				&x.ID, &x.Name, &x.Detail, &x.Photo, pq.Array(&x.Tags), &x.CreatedAt,
+				&x.ID, &x.Name, &x.Detail, &x.Photo, pq.Array(&x.Tags), &x.Visibility, &x.Status, &x.PublishAt, &x.IsPinned,
+				&x.FavoriteCount, &x.CommentCount, &x.CreatedAt,
 			)
 			if err != nil {
 				return nil, err
@@ -147,7 +181,11 @@ func GetMyFavoriteWorks(ctx context.Context, req *GetMyFavoriteWorksRequest) (*G
 		err := req.Paginate.CountFrom(func() (cnt int64, err error) {
 			// language=SQL
 			err = pgctx.QueryRow(ctx, `
-				select count(*) from favorites where user_id = $1
+				select count(*)
+				from favorites f
+					join works w on f.work_id = w.id
+				where f.user_id = $1
+				  and can_view_work(w.user_id, w.visibility, w.status, $1)
 			`, userID).Scan(&cnt)
 			return
 		})
@@ -160,10 +198,12 @@ func GetMyFavoriteWorks(ctx context.Context, req *GetMyFavoriteWorksRequest) (*G
 		// language=SQL
 		rows, err := pgctx.Query(ctx, `
 			select
-				w.id, w.name, w.detail, w.photo, w.tags, w.created_at
+				w.id, w.name, w.detail, w.photo, w.tags, w.visibility, w.status, w.publish_at, w.pin_order is not null,
+				w.favorite_count, w.comment_count, w.created_at
 			from favorites f
-				left join works w on f.work_id = w.id
+				join works w on f.work_id = w.id
 			where f.user_id = $3
+			  and can_view_work(w.user_id, w.visibility, w.status, $3)
 			offset $1 limit $2
 		`, req.Paginate.Offset(), req.Paginate.Limit(), userID)
 		if err != nil {
@@ -177,7 +217,8 @@ func GetMyFavoriteWorks(ctx context.Context, req *GetMyFavoriteWorksRequest) (*G
 		for rows.Next() {
 			var x MyWorkItem
 			err := rows.Scan(
-				&x.ID, &x.Name, &x.Detail, &x.Photo, pq.Array(&x.Tags), &x.CreatedAt,
+				&x.ID, &x.Name, &x.Detail, &x.Photo, pq.Array(&x.Tags), &x.Visibility, &x.Status, &x.PublishAt, &x.IsPinned,
+				&x.FavoriteCount, &x.CommentCount, &x.CreatedAt,
 			)
 			if err != nil {
 				return nil, err
@@ -196,10 +237,13 @@ func GetMyFavoriteWorks(ctx context.Context, req *GetMyFavoriteWorksRequest) (*G
 }
 
 type CreateWorkRequest struct {
-	Name   string
-	Detail string
-	Photo  *multipart.FileHeader
-	Tags   []string
+	Name       string
+	Detail     string
+	Photo      *multipart.FileHeader
+	Tags       []string
+	Visibility string
+	Status     string
+	PublishAt  time.Time // required for scheduled work
 }
 
 func (req *CreateWorkRequest) UnmarshalJSON(_ []byte) error {
@@ -213,6 +257,16 @@ func (req *CreateWorkRequest) UnmarshalMultipartForm(v *multipart.Form) error {
 	if p := v.Value["detail"]; len(p) == 1 {
 		req.Detail = p[0]
 	}
+	if p := v.Value["visibility"]; len(p) == 1 {
+		req.Visibility = p[0]
+	}
+	if p := v.Value["status"]; len(p) == 1 {
+		req.Status = p[0]
+	}
+	if p := v.Value["publishAt"]; len(p) == 1 {
+		// invalid time will be reported by Valid
+		req.PublishAt, _ = time.Parse(time.RFC3339, p[0])
+	}
 	req.Tags = v.Value["tags"]
 	for i := range req.Tags {
 		req.Tags[i] = strings.TrimSpace(req.Tags[i])
@@ -236,18 +290,37 @@ func (req *CreateWorkRequest) Valid() error {
 	for i, t := range req.Tags {
 		v.Must(validator.IsTag(t), fmt.Sprintf("tags[%d] is not valid tag", i))
 	}
+	if req.Visibility == "" {
+		req.Visibility = visibility.Public
+	}
+	v.Must(visibility.Valid(req.Visibility), "invalid visibility")
+	if req.Status == "" {
+		req.Status = publish.Published
+	}
+	v.Must(req.Status == publish.Draft || req.Status == publish.Scheduled || req.Status == publish.Published, "invalid status")
+	if req.Status == publish.Scheduled {
+		v.Must(!req.PublishAt.IsZero(), "publishAt required")
+		v.Must(req.PublishAt.IsZero() || req.PublishAt.After(time.Now()), "publishAt must be in the future")
+	}
 
 	return v.Error()
 }
 
 type CreateWorkResult struct {
-	ID     string           `json:"id"`
-	Name   string           `json:"name"`
-	Detail string           `json:"detail"`
-	Photo  file.DownloadURL `json:"photo"`
-	Tags   []string         `json:"tags"`
+	ID         string           `json:"id"`
+	Name       string           `json:"name"`
+	Detail     string           `json:"detail"`
+	Mentions   mention.Entities `json:"mentions"`
+	Photo      file.DownloadURL `json:"photo"`
+	Tags       []string         `json:"tags"`
+	Visibility string           `json:"visibility"`
+	Status     string           `json:"status"`
+	PublishAt  *time.Time       `json:"publishAt"`
 }
 
+// CreateWork creates new work,
+// draft work will not be published until published by owner,
+// scheduled work will be published by scheduler at publish time
 func CreateWork(ctx context.Context, req *CreateWorkRequest) (*CreateWorkResult, error) {
 	userID := session.GetUserID(ctx)
 	if userID == "" {
@@ -279,32 +352,84 @@ func CreateWork(ctx context.Context, req *CreateWorkRequest) (*CreateWorkResult,
 		return nil, err
 	}
 
+	// uploaded file may contain metadata (ex. location), only owner can get it from data export
+	original := file.GeneratePrivateFilename(ext)
+	_, err = fp.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	err = file.Store(ctx, file.File{
+		Reader:      fp,
+		Name:        original,
+		ContentType: image.ContentType(ext),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var publishAt *time.Time
+	switch req.Status {
+	case publish.Published:
+		t := time.Now()
+		publishAt = &t
+	case publish.Scheduled:
+		publishAt = &req.PublishAt
+	}
+
 	req.Tags = append([]string{}, req.Tags...)
-	id, err := insertWorkPhoto(ctx, &insertWorkPhotoParam{
-		UserID: userID,
-		Name:   req.Name,
-		Detail: req.Detail,
-		Photo:  fn,
-		Tags:   req.Tags,
+	var (
+		r  CreateWorkResult
+		id int64
+	)
+	err = pgctx.RunInTx(ctx, func(ctx context.Context) error {
+		var err error
+		id, err = insertWorkPhoto(ctx, &insertWorkPhotoParam{
+			UserID:     userID,
+			Name:       req.Name,
+			Detail:     req.Detail,
+			Photo:      fn,
+			Original:   original,
+			Tags:       req.Tags,
+			Visibility: req.Visibility,
+			Status:     req.Status,
+			PublishAt:  publishAt,
+		})
+		if err != nil {
+			return err
+		}
+		r.ID = strconv.FormatInt(id, 10)
+
+		// mentioned users in not published work get notified when published
+		r.Mentions, err = mention.SetWork(ctx, userID, r.ID, req.Detail)
+		return err
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	var r CreateWorkResult
-	r.ID = strconv.FormatInt(id, 10)
+	if req.Status == publish.Published {
+		err = feed.AddWork(ctx, userID, id, *publishAt)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	r.Name = req.Name
 	r.Detail = req.Detail
 	r.Photo = file.DownloadURL(fn)
 	r.Tags = req.Tags
+	r.Visibility = req.Visibility
+	r.Status = req.Status
+	r.PublishAt = publishAt
 	return &r, nil
 }
 
 type UpdateWorkRequest struct {
-	ID     string   `json:"id"`
-	Name   string   `json:"name"`
-	Detail string   `json:"detail"`
-	Tags   []string `json:"tags"`
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Detail     string   `json:"detail"`
+	Tags       []string `json:"tags"`
+	Visibility string   `json:"visibility"` // empty to keep current visibility
 }
 
 func (req *UpdateWorkRequest) Valid() error {
@@ -317,6 +442,7 @@ func (req *UpdateWorkRequest) Valid() error {
 	for i, t := range req.Tags {
 		v.Must(validator.IsTag(t), fmt.Sprintf("tags[%d] is not valid tag", i))
 	}
+	v.Must(req.Visibility == "" || visibility.Valid(req.Visibility), "invalid visibility")
 
 	return v.Error()
 }
@@ -327,7 +453,7 @@ func UpdateWork(ctx context.Context, req *UpdateWorkRequest) (*struct{}, error)
 		return nil, errInvalidCredentials
 	}
 
-	{
+	err := pgctx.RunInTx(ctx, func(ctx context.Context) error {
 		isExists := false
 		// language=SQL
 		err := pgctx.QueryRow(ctx, `
@@ -338,25 +464,31 @@ func UpdateWork(ctx context.Context, req *UpdateWorkRequest) (*struct{}, error)
 			)
 		`, userID, req.ID).Scan(&isExists)
 		if err != nil {
-			return nil, err
+			return err
 		}
 
 		if !isExists {
-			return nil, errWorkNotFound
+			return errWorkNotFound
 		}
-	}
 
-	{
 		req.Tags = append([]string{}, req.Tags...)
-		err := updateWork(ctx, &updateWorkParam{
-			ID:     req.ID,
-			Name:   req.Name,
-			Detail: req.Detail,
-			Tags:   req.Tags,
+		err = updateWork(ctx, &updateWorkParam{
+			ID:         req.ID,
+			Name:       req.Name,
+			Detail:     req.Detail,
+			Tags:       req.Tags,
+			Visibility: req.Visibility,
 		})
 		if err != nil {
-			return nil, err
+			return err
 		}
+
+		// users already notified will not be notified again
+		_, err = mention.SetWork(ctx, userID, req.ID, req.Detail)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return new(struct{}), nil
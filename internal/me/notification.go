@@ -0,0 +1,148 @@
+package me
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/acoshift/pgsql/pgctx"
+	"github.com/asaskevich/govalidator"
+	"github.com/lib/pq"
+
+	"github.com/acoshift/pikkanode/internal/file"
+	"github.com/acoshift/pikkanode/internal/paginate"
+	"github.com/acoshift/pikkanode/internal/session"
+	"github.com/acoshift/pikkanode/internal/validator"
+)
+
+type GetNotificationsRequest struct {
+	Paginate paginate.Paginate `json:"paginate"`
+}
+
+type NotificationItem struct {
+	ID    string `json:"id"`
+	Type  string `json:"type"` // mention
+	Actor struct {
+		Username string           `json:"username"`
+		Photo    file.DownloadURL `json:"photo"`
+	} `json:"actor"`
+	WorkID    *string   `json:"workId"`
+	CommentID *string   `json:"commentId"`
+	IsRead    bool      `json:"isRead"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type GetNotificationsResult struct {
+	List        []*NotificationItem `json:"list"`
+	Paginate    paginate.Paginate   `json:"paginate"`
+	UnreadCount int64               `json:"unreadCount"`
+}
+
+// notificationsFilter hides notifications from blocked users,
+// and notifications of works and comments no longer visible
+// language=SQL
+const notificationsFilter = `
+	from notifications n
+		join users u on n.actor_id = u.id
+		left join works w on n.work_id = w.id
+		left join comments c on n.comment_id = c.id
+	where n.user_id = $1
+	  and not is_blocked(n.actor_id, $1)
+	  and (w.id is null or can_view_work(w.user_id, w.visibility, w.status, $1))
+	  and (c.id is null or c.deleted_at is null)
+`
+
+// GetNotifications lists my notifications, newest first
+func GetNotifications(ctx context.Context, req *GetNotificationsRequest) (*GetNotificationsResult, error) {
+	userID := session.GetUserID(ctx)
+	if userID == "" {
+		return nil, errInvalidCredentials
+	}
+
+	var r GetNotificationsResult
+	{
+		err := req.Paginate.CountFrom(func() (cnt int64, err error) {
+			// language=SQL
+			err = pgctx.QueryRow(ctx, `
+				select count(*), count(*) filter (where n.read_at is null)
+			`+notificationsFilter, userID).Scan(&cnt, &r.UnreadCount)
+			return
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	{
+		// language=SQL
+		rows, err := pgctx.Query(ctx, `
+			select
+				n.id, n.type, u.username, u.photo,
+				n.work_id, n.comment_id, n.read_at is not null, n.created_at
+		`+notificationsFilter+`
+			order by n.created_at desc
+			offset $2 limit $3
+		`, userID, req.Paginate.Offset(), req.Paginate.Limit())
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		r.List = make([]*NotificationItem, 0)
+		r.Paginate = req.Paginate
+
+		for rows.Next() {
+			var x NotificationItem
+			err := rows.Scan(
+				&x.ID, &x.Type, &x.Actor.Username, &x.Actor.Photo,
+				&x.WorkID, &x.CommentID, &x.IsRead, &x.CreatedAt,
+			)
+			if err != nil {
+				return nil, err
+			}
+			r.List = append(r.List, &x)
+		}
+
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+
+		rows.Close()
+	}
+
+	return &r, nil
+}
+
+type ReadNotificationsRequest struct {
+	IDs []string `json:"ids"` // empty to mark all as read
+}
+
+func (req *ReadNotificationsRequest) Valid() error {
+	v := validator.New()
+	for i, id := range req.IDs {
+		v.Must(govalidator.IsUUID(id), fmt.Sprintf("ids[%d] is not valid id", i))
+	}
+
+	return v.Error()
+}
+
+// ReadNotifications marks my notifications as read
+func ReadNotifications(ctx context.Context, req *ReadNotificationsRequest) (*struct{}, error) {
+	userID := session.GetUserID(ctx)
+	if userID == "" {
+		return nil, errInvalidCredentials
+	}
+
+	// language=SQL
+	_, err := pgctx.Exec(ctx, `
+		update notifications
+		set read_at = now()
+		where user_id = $1 and read_at is null
+		  and (coalesce(cardinality($2::uuid[]), 0) = 0 or id = any($2::uuid[]))
+	`, userID, pq.Array(req.IDs))
+	if err != nil {
+		return nil, err
+	}
+
+	return new(struct{}), nil
+}
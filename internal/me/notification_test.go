@@ -0,0 +1,82 @@
+package me_test
+
+import (
+	"testing"
+
+	"github.com/acoshift/pgsql/pgctx"
+
+	"github.com/acoshift/pikkanode/internal/me"
+	"github.com/acoshift/pikkanode/internal/testdb"
+	"github.com/acoshift/pikkanode/internal/work"
+)
+
+func TestMain(m *testing.M) {
+	testdb.Main(m)
+}
+
+func TestReadNotifications(t *testing.T) {
+	ctx := testdb.Context(t)
+
+	ownerID, _ := testdb.CreateUser(ctx, t)
+	userID, username := testdb.CreateUser(ctx, t)
+	ownerCtx := testdb.WithUser(ctx, ownerID)
+	userCtx := testdb.WithUser(ctx, userID)
+
+	var workID string
+	// language=SQL
+	err := pgctx.QueryRow(ctx, `
+		insert into works
+			(user_id, name, photo)
+		values
+			($1, 'work', '')
+		returning id
+	`, ownerID).Scan(&workID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		c, err := work.PostComment(ownerCtx, &work.CommentRequest{ID: workID, Content: "hi @" + username})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(c.Mentions) != 1 || c.Mentions[0].Username != username {
+			t.Fatalf("expected mention %s; got %v", username, c.Mentions)
+		}
+	}
+
+	unread := func() int64 {
+		t.Helper()
+
+		r, err := me.GetNotifications(userCtx, &me.GetNotificationsRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r.UnreadCount
+	}
+
+	if n := unread(); n != 3 {
+		t.Fatalf("expected 3 unread notifications; got %d", n)
+	}
+
+	r, err := me.GetNotifications(userCtx, &me.GetNotificationsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = me.ReadNotifications(userCtx, &me.ReadNotificationsRequest{IDs: []string{r.List[0].ID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := unread(); n != 2 {
+		t.Fatalf("expected 2 unread notifications; got %d", n)
+	}
+
+	// no ids marks all as read
+	_, err = me.ReadNotifications(userCtx, &me.ReadNotificationsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := unread(); n != 0 {
+		t.Fatalf("expected all notifications read; got %d unread", n)
+	}
+}
@@ -0,0 +1,69 @@
+package me
+
+import (
+	"context"
+	"unicode/utf8"
+
+	"github.com/acoshift/pgsql/pgctx"
+
+	"github.com/acoshift/pikkanode/internal/password"
+	"github.com/acoshift/pikkanode/internal/session"
+	"github.com/acoshift/pikkanode/internal/validator"
+)
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+
+func (req *ChangePasswordRequest) Valid() error {
+	v := validator.New()
+	v.Must(utf8.RuneCountInString(req.CurrentPassword) <= 500, "current password maximum 500 characters")
+
+	v.Must(req.NewPassword != "", "new password required")
+	v.Must(utf8.RuneCountInString(req.NewPassword) >= 6, "new password minimum 6 characters")
+	v.Must(utf8.RuneCountInString(req.NewPassword) <= 500, "new password maximum 500 characters")
+
+	return v.Error()
+}
+
+func ChangePassword(ctx context.Context, req *ChangePasswordRequest) (*struct{}, error) {
+	userID := session.GetUserID(ctx)
+	if userID == "" {
+		return nil, errInvalidCredentials
+	}
+
+	hashed, err := getUserPassword(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// user signed up using oidc do not have password
+	if hashed != "" && !password.Compare(hashed, req.CurrentPassword) {
+		return nil, errInvalidPassword
+	}
+
+	hashed, err = password.Hash(req.NewPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	// access tokens may be created by whoever knew the old password
+	err = pgctx.RunInTx(ctx, func(ctx context.Context) error {
+		err := setUserPassword(ctx, userID, hashed)
+		if err != nil {
+			return err
+		}
+		return deleteAccessTokens(ctx, userID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = session.RevokeAll(userID, session.CurrentID(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	return new(struct{}), nil
+}
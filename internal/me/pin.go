@@ -0,0 +1,109 @@
+package me
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/acoshift/pgsql/pgctx"
+	"github.com/asaskevich/govalidator"
+
+	"github.com/acoshift/pikkanode/internal/session"
+	"github.com/acoshift/pikkanode/internal/validator"
+)
+
+// maxPinnedWorks is the maximum number of pinned works per user
+const maxPinnedWorks = 5
+
+type PinWorkRequest struct {
+	ID string `json:"id"`
+}
+
+func (req *PinWorkRequest) Valid() error {
+	v := validator.New()
+	v.Must(req.ID != "", "id required")
+	v.Must(govalidator.IsNumeric(req.ID), "invalid id")
+
+	return v.Error()
+}
+
+// PinWork pins my work after already pinned works
+func PinWork(ctx context.Context, req *PinWorkRequest) (*struct{}, error) {
+	userID := session.GetUserID(ctx)
+	if userID == "" {
+		return nil, errInvalidCredentials
+	}
+
+	err := pgctx.RunInTx(ctx, func(ctx context.Context) error {
+		err := lockUser(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		var pinOrder sql.NullInt64
+		// language=SQL
+		err = pgctx.QueryRow(ctx, `
+			select pin_order from works where id = $1 and user_id = $2
+		`, req.ID, userID).Scan(&pinOrder)
+		if err == sql.ErrNoRows {
+			return errWorkNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if pinOrder.Valid {
+			// already pinned
+			return nil
+		}
+
+		// language=SQL
+		res, err := pgctx.Exec(ctx, `
+			update works
+			set pin_order = (select coalesce(max(pin_order) + 1, 0) from works where user_id = $2)
+			where id = $1 and user_id = $2 and pin_order is null
+			  and (select count(*) from works where user_id = $2 and pin_order is not null) < $3
+		`, req.ID, userID, maxPinnedWorks)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return errPinLimitExceeded
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return new(struct{}), nil
+}
+
+type UnpinWorkRequest struct {
+	ID string `json:"id"`
+}
+
+func (req *UnpinWorkRequest) Valid() error {
+	v := validator.New()
+	v.Must(req.ID != "", "id required")
+	v.Must(govalidator.IsNumeric(req.ID), "invalid id")
+
+	return v.Error()
+}
+
+func UnpinWork(ctx context.Context, req *UnpinWorkRequest) (*struct{}, error) {
+	userID := session.GetUserID(ctx)
+	if userID == "" {
+		return nil, errInvalidCredentials
+	}
+
+	// language=SQL
+	_, err := pgctx.Exec(ctx, `
+		update works
+		set pin_order = null
+		where id = $1 and user_id = $2
+	`, req.ID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(struct{}), nil
+}
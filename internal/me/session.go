@@ -0,0 +1,91 @@
+package me
+
+import (
+	"context"
+	"time"
+
+	"github.com/acoshift/pikkanode/internal/session"
+	"github.com/acoshift/pikkanode/internal/validator"
+)
+
+type SessionItem struct {
+	ID         string    `json:"id"`
+	Device     string    `json:"device"`
+	UserAgent  string    `json:"userAgent"`
+	IP         string    `json:"ip"`
+	Current    bool      `json:"current"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+}
+
+type GetSessionsResult struct {
+	List []*SessionItem `json:"list"`
+}
+
+func GetSessions(ctx context.Context, _ *struct{}) (*GetSessionsResult, error) {
+	userID := session.GetUserID(ctx)
+	if userID == "" {
+		return nil, errInvalidCredentials
+	}
+
+	xs, err := session.List(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	currentID := session.CurrentID(ctx)
+
+	var r GetSessionsResult
+	r.List = make([]*SessionItem, 0, len(xs))
+	for _, x := range xs {
+		r.List = append(r.List, &SessionItem{
+			ID:         x.ID,
+			Device:     x.Device,
+			UserAgent:  x.UserAgent,
+			IP:         x.IP,
+			Current:    x.ID == currentID,
+			CreatedAt:  x.CreatedAt,
+			LastSeenAt: x.LastSeenAt,
+		})
+	}
+	return &r, nil
+}
+
+type RevokeSessionRequest struct {
+	ID string `json:"id"`
+}
+
+func (req *RevokeSessionRequest) Valid() error {
+	v := validator.New()
+	v.Must(req.ID != "", "id required")
+
+	return v.Error()
+}
+
+func RevokeSession(ctx context.Context, req *RevokeSessionRequest) (*struct{}, error) {
+	userID := session.GetUserID(ctx)
+	if userID == "" {
+		return nil, errInvalidCredentials
+	}
+
+	err := session.Revoke(userID, req.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(struct{}), nil
+}
+
+func RevokeOtherSessions(ctx context.Context, _ *struct{}) (*struct{}, error) {
+	userID := session.GetUserID(ctx)
+	if userID == "" {
+		return nil, errInvalidCredentials
+	}
+
+	err := session.RevokeAll(userID, session.CurrentID(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	return new(struct{}), nil
+}
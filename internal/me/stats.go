@@ -0,0 +1,134 @@
+package me
+
+import (
+	"context"
+	"time"
+
+	"github.com/acoshift/pgsql/pgctx"
+	"github.com/asaskevich/govalidator"
+
+	"github.com/acoshift/pikkanode/internal/session"
+	"github.com/acoshift/pikkanode/internal/validator"
+)
+
+// Stats intervals
+const (
+	IntervalDay   = "day"
+	IntervalWeek  = "week"
+	IntervalMonth = "month"
+)
+
+// maxStatsPoints is the maximum number of points in stats result
+const maxStatsPoints = 400
+
+var intervalDuration = map[string]time.Duration{
+	IntervalDay:   24 * time.Hour,
+	IntervalWeek:  7 * 24 * time.Hour,
+	IntervalMonth: 30 * 24 * time.Hour,
+}
+
+type GetFavoriteStatsRequest struct {
+	WorkID   string    `json:"workId"`   // empty for all my works
+	Interval string    `json:"interval"` // day (default), week or month
+	From     time.Time `json:"from"`     // default 30 days before to
+	To       time.Time `json:"to"`       // default now
+}
+
+func (req *GetFavoriteStatsRequest) Valid() error {
+	if req.Interval == "" {
+		req.Interval = IntervalDay
+	}
+	if req.To.IsZero() {
+		req.To = time.Now()
+	}
+	if req.From.IsZero() {
+		req.From = req.To.AddDate(0, 0, -30)
+	}
+
+	v := validator.New()
+	v.Must(req.WorkID == "" || govalidator.IsNumeric(req.WorkID), "invalid work id")
+	v.Must(intervalDuration[req.Interval] > 0, "invalid interval")
+	v.Must(req.From.Before(req.To), "from must be before to")
+	if d := intervalDuration[req.Interval]; d > 0 {
+		v.Must(req.To.Sub(req.From)/d <= maxStatsPoints, "time range too large for interval")
+	}
+
+	return v.Error()
+}
+
+type StatsPoint struct {
+	Time  time.Time `json:"time"`
+	Count int64     `json:"count"`
+}
+
+type GetFavoriteStatsResult struct {
+	List  []*StatsPoint `json:"list"`
+	Total int64         `json:"total"`
+}
+
+// GetFavoriteStats returns number of favorites my works received over time
+func GetFavoriteStats(ctx context.Context, req *GetFavoriteStatsRequest) (*GetFavoriteStatsResult, error) {
+	userID := session.GetUserID(ctx)
+	if userID == "" {
+		return nil, errInvalidCredentials
+	}
+
+	if req.WorkID != "" {
+		isExists := false
+		// language=SQL
+		err := pgctx.QueryRow(ctx, `
+			select exists(
+				select 1
+				from works
+				where id = $1 and user_id = $2
+			)
+		`, req.WorkID, userID).Scan(&isExists)
+		if err != nil {
+			return nil, err
+		}
+		if !isExists {
+			return nil, errWorkNotFound
+		}
+	}
+
+	// language=SQL
+	rows, err := pgctx.Query(ctx, `
+		with t as (
+			select date_trunc($3, f.created_at) as t, count(*) as cnt
+			from favorites f
+				join works w on f.work_id = w.id
+			where w.user_id = $1 and ($2 = '' or w.id = nullif($2, '')::bigint)
+			  and f.created_at >= $4 and f.created_at < $5
+			group by 1
+		)
+		select g.t, coalesce(t.cnt, 0)
+		from generate_series(
+			date_trunc($3, $4::timestamp),
+			$5::timestamp - interval '1 microsecond',
+			('1 ' || $3)::interval
+		) as g (t)
+			left join t on t.t = g.t
+		order by g.t
+	`, userID, req.WorkID, req.Interval, req.From.UTC(), req.To.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var r GetFavoriteStatsResult
+	r.List = make([]*StatsPoint, 0)
+	for rows.Next() {
+		var x StatsPoint
+		err := rows.Scan(&x.Time, &x.Count)
+		if err != nil {
+			return nil, err
+		}
+		r.List = append(r.List, &x)
+		r.Total += x.Count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
@@ -0,0 +1,110 @@
+package me
+
+import (
+	"context"
+	"time"
+	"unicode/utf8"
+
+	"github.com/acoshift/pgsql"
+	"github.com/acoshift/pgsql/pgctx"
+
+	"github.com/acoshift/pikkanode/internal/config"
+	"github.com/acoshift/pikkanode/internal/session"
+	"github.com/acoshift/pikkanode/internal/username"
+	"github.com/acoshift/pikkanode/internal/validator"
+)
+
+type ChangeUsernameRequest struct {
+	Username string `json:"username"`
+}
+
+func (req *ChangeUsernameRequest) Valid() error {
+	v := validator.New()
+	v.Must(req.Username != "", "username required")
+	v.Must(utf8.RuneCountInString(req.Username) >= username.MinLength, "username minimum 6 characters")
+	v.Must(utf8.RuneCountInString(req.Username) <= username.MaxLength, "username maximum 15 characters")
+	v.Must(username.Valid(req.Username), "invalid username")
+	v.Must(username.Allowed(req.Username), "username not available")
+
+	return v.Error()
+}
+
+func ChangeUsername(ctx context.Context, req *ChangeUsernameRequest) (*struct{}, error) {
+	userID := session.GetUserID(ctx)
+	if userID == "" {
+		return nil, errInvalidCredentials
+	}
+
+	err := pgctx.RunInTx(ctx, func(ctx context.Context) error {
+		var (
+			current   string
+			changedAt *time.Time
+		)
+		// lock user, concurrent changes can not pass cooldown check together
+		// language=SQL
+		err := pgctx.QueryRow(ctx, `
+			select username, username_changed_at
+			from users
+			where id = $1
+			for update
+		`, userID).Scan(&current, &changedAt)
+		if err != nil {
+			return err
+		}
+		if current == req.Username {
+			return nil
+		}
+		if changedAt != nil && time.Since(*changedAt) < config.UsernameChangeCooldown() {
+			return errUsernameChangeCooldown
+		}
+
+		held, err := username.Held(ctx, req.Username, userID)
+		if err != nil {
+			return err
+		}
+		if held {
+			return errUsernameNotAvailable
+		}
+
+		// language=SQL
+		_, err = pgctx.Exec(ctx, `
+			insert into username_history
+				(username, user_id)
+			values
+				($1, $2)
+			on conflict ((lower(username))) do update
+			set user_id = excluded.user_id,
+			    created_at = now()
+		`, current, userID)
+		if err != nil {
+			return err
+		}
+
+		// user reclaim their old username
+		// language=SQL
+		_, err = pgctx.Exec(ctx, `
+			delete from username_history
+			where lower(username) = lower($1) and user_id = $2
+		`, req.Username, userID)
+		if err != nil {
+			return err
+		}
+
+		// language=SQL
+		_, err = pgctx.Exec(ctx, `
+			update users
+			set username = $2,
+			    username_changed_at = now()
+			where id = $1
+		`, userID, req.Username)
+		return err
+	})
+	if pgsql.IsUniqueViolation(err, "users_username_idx") {
+		return nil, errUsernameNotAvailable
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return new(struct{}), nil
+}
@@ -0,0 +1,246 @@
+package mention
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/acoshift/pgsql/pgctx"
+	"github.com/lib/pq"
+
+	"github.com/acoshift/pikkanode/internal/username"
+)
+
+// notificationType is the type of notification sent to mentioned user
+const notificationType = "mention"
+
+var re = regexp.MustCompile(`@([a-zA-Z0-9]+)`)
+
+// Entity is a mention in text, offset and length are in characters
+type Entity struct {
+	Offset   int    `json:"offset"`
+	Length   int    `json:"length"`
+	Username string `json:"username"`
+}
+
+// Entities is a list of mentions ordered by offset
+type Entities []*Entity
+
+// Scan scans json array from database
+func (xs *Entities) Scan(src interface{}) error {
+	*xs = make(Entities, 0)
+	switch src := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(src, xs)
+	case string:
+		return json.Unmarshal([]byte(src), xs)
+	default:
+		return fmt.Errorf("mention: can not scan %T into Entities", src)
+	}
+}
+
+// Parse returns mentions in s, username is as written in s
+func Parse(s string) Entities {
+	xs := make(Entities, 0)
+	for _, m := range re.FindAllStringSubmatchIndex(s, -1) {
+		// skip email address and the likes
+		if m[0] > 0 {
+			c, _ := utf8.DecodeLastRuneInString(s[:m[0]])
+			if c == '@' || username.ValidChar(c) {
+				continue
+			}
+		}
+
+		name := s[m[2]:m[3]]
+		if len(name) > username.MaxLength {
+			continue
+		}
+
+		xs = append(xs, &Entity{
+			Offset:   utf8.RuneCountInString(s[:m[0]]),
+			Length:   utf8.RuneCountInString(s[m[0]:m[1]]),
+			Username: name,
+		})
+	}
+	return xs
+}
+
+type mentionedUser struct {
+	ID       string
+	Username string
+}
+
+// resolve returns mentioned users who exist and not blocked with actor,
+// keyed by lower case username
+func resolve(ctx context.Context, actorID string, xs Entities) (map[string]*mentionedUser, error) {
+	m := make(map[string]*mentionedUser)
+	if len(xs) == 0 {
+		return m, nil
+	}
+
+	names := make([]string, 0, len(xs))
+	for _, x := range xs {
+		names = append(names, strings.ToLower(x.Username))
+	}
+
+	// language=SQL
+	rows, err := pgctx.Query(ctx, `
+		select id, username
+		from users
+		where lower(username) = any($1)
+		  and not is_blocked(id, $2)
+	`, pq.Array(names), actorID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var x mentionedUser
+		err := rows.Scan(&x.ID, &x.Username)
+		if err != nil {
+			return nil, err
+		}
+		m[strings.ToLower(x.Username)] = &x
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// set replaces mentions of the target with mentions in text,
+// returns saved mentions with current usernames,
+// call inside the transaction writing the target
+func set(ctx context.Context, actorID, column, targetID, text string) (Entities, error) {
+	xs := Parse(text)
+	users, err := resolve(ctx, actorID, xs)
+	if err != nil {
+		return nil, err
+	}
+
+	r := make(Entities, 0, len(xs))
+	err = pgctx.RunInTx(ctx, func(ctx context.Context) error {
+		// language=SQL
+		_, err := pgctx.Exec(ctx, `delete from mentions where `+column+` = $1`, targetID)
+		if err != nil {
+			return err
+		}
+
+		for _, x := range xs {
+			u := users[strings.ToLower(x.Username)]
+			if u == nil {
+				continue
+			}
+
+			// language=SQL
+			_, err := pgctx.Exec(ctx, `
+				insert into mentions
+					(user_id, `+column+`, position, length)
+				values
+					($1, $2, $3, $4)
+			`, u.ID, targetID, x.Offset, x.Length)
+			if err != nil {
+				return err
+			}
+			r = append(r, &Entity{
+				Offset:   x.Offset,
+				Length:   x.Length,
+				Username: u.Username,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// SetWork replaces mentions in work's detail,
+// and notifies mentioned users who can view the work
+func SetWork(ctx context.Context, actorID, workID, detail string) (Entities, error) {
+	xs, err := set(ctx, actorID, "work_id", workID, detail)
+	if err != nil {
+		return nil, err
+	}
+
+	err = NotifyWork(ctx, workID)
+	if err != nil {
+		return nil, err
+	}
+
+	return xs, nil
+}
+
+// SetComment replaces mentions in comment's content,
+// and notifies mentioned users who can view the comment,
+// empty content removes all mentions
+func SetComment(ctx context.Context, actorID, commentID, content string) (Entities, error) {
+	xs, err := set(ctx, actorID, "comment_id", commentID, content)
+	if err != nil {
+		return nil, err
+	}
+
+	err = NotifyComment(ctx, commentID)
+	if err != nil {
+		return nil, err
+	}
+
+	return xs, nil
+}
+
+// NotifyWork notifies users mentioned in work who can view the work,
+// each user gets notified only once per work,
+// call after work becomes visible to more users, e.g. published
+func NotifyWork(ctx context.Context, workID string) error {
+	// language=SQL
+	_, err := pgctx.Exec(ctx, `
+		insert into notifications
+			(user_id, actor_id, type, work_id)
+		select distinct m.user_id, w.user_id, $2, w.id
+		from mentions m
+			join works w on m.work_id = w.id
+		where m.work_id = $1
+		  and m.user_id != w.user_id
+		  and can_view_work(w.user_id, w.visibility, w.status, m.user_id)
+		  and not exists(
+		      select 1
+		      from notifications n
+		      where n.user_id = m.user_id and n.type = $2 and n.work_id = w.id and n.comment_id is null
+		  )
+	`, workID, notificationType)
+	return err
+}
+
+// NotifyComment notifies users mentioned in comment who can view the comment,
+// each user gets notified only once per comment
+func NotifyComment(ctx context.Context, commentID string) error {
+	// language=SQL
+	_, err := pgctx.Exec(ctx, `
+		insert into notifications
+			(user_id, actor_id, type, work_id, comment_id)
+		select distinct m.user_id, c.user_id, $2, c.work_id, c.id
+		from mentions m
+			join comments c on m.comment_id = c.id
+			join works w on c.work_id = w.id
+		where m.comment_id = $1
+		  and m.user_id != c.user_id
+		  and c.deleted_at is null
+		  and can_view_work(w.user_id, w.visibility, w.status, m.user_id)
+		  and can_view_comment(c, w.user_id, m.user_id)
+		  and not exists(
+		      select 1
+		      from notifications n
+		      where n.user_id = m.user_id and n.type = $2 and n.comment_id = c.id
+		  )
+	`, commentID, notificationType)
+	return err
+}
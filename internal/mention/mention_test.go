@@ -0,0 +1,59 @@
+package mention_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/acoshift/pikkanode/internal/mention"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		Name string
+		Text string
+		Want mention.Entities
+	}{
+		{"empty", "", mention.Entities{}},
+		{"no mention", "hello world", mention.Entities{}},
+		{"only at", "@", mention.Entities{}},
+		{"start", "@alice hi", mention.Entities{{0, 6, "alice"}}},
+		{"end", "hi @alice", mention.Entities{{3, 6, "alice"}}},
+		{"whole text", "@alice", mention.Entities{{0, 6, "alice"}}},
+		{"case kept", "hi @AliceB", mention.Entities{{3, 7, "AliceB"}}},
+		{"multiple", "@alice and @bob123", mention.Entities{{0, 6, "alice"}, {11, 7, "bob123"}}},
+		{"same user twice", "@alice @alice", mention.Entities{{0, 6, "alice"}, {7, 6, "alice"}}},
+		{"punctuation", "(@alice), @bob.", mention.Entities{{1, 6, "alice"}, {10, 4, "bob"}}},
+		{"not username char", "@alice_bob", mention.Entities{{0, 6, "alice"}}},
+		{"newline", "hi\n@alice", mention.Entities{{3, 6, "alice"}}},
+
+		{"email", "mail me at alice@example.com", mention.Entities{}},
+		{"email and mention", "bob@example.com @alice", mention.Entities{{16, 6, "alice"}}},
+		{"double at", "@@alice", mention.Entities{}},
+		{"chained", "@alice@bob", mention.Entities{{0, 6, "alice"}}},
+
+		{"maximum length", "@abcdefghijklmno", mention.Entities{{0, 16, "abcdefghijklmno"}}},
+		{"too long", "@abcdefghijklmnop", mention.Entities{}},
+
+		// offsets are in characters, not bytes
+		{"thai before", "สวัสดี @alice", mention.Entities{{7, 6, "alice"}}},
+		{"thai adjacent", "ขอบคุณ@alice", mention.Entities{{6, 6, "alice"}}},
+		{"emoji before", "😀 @alice 😀 @bob", mention.Entities{{2, 6, "alice"}, {11, 4, "bob"}}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			got := mention.Parse(tc.Text)
+			if !reflect.DeepEqual(got, tc.Want) {
+				t.Errorf("got %v; want %v", format(got), format(tc.Want))
+			}
+		})
+	}
+}
+
+func format(xs mention.Entities) []mention.Entity {
+	r := make([]mention.Entity, 0, len(xs))
+	for _, x := range xs {
+		r = append(r, *x)
+	}
+	return r
+}
@@ -8,32 +8,93 @@ import (
 	"strconv"
 	"strings"
 
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/scrypt"
 )
 
+// Hashed password formats
+//
+//	argon2id: $argon2id$v=19$m=65536,t=1,p=4$salt$key
+//	scrypt (legacy, no prefix): n$r$p$salt$key
+const argon2idPrefix = "$argon2id$"
+
+// argon2id parameters for new hash
+const (
+	argonTime    = 1
+	argonMemory  = 64 * 1024
+	argonThreads = 4
+)
+
 const (
-	n = 32768
-	r = 8
-	p = 1
 	s = 16
 	k = 32
 )
 
+// Hash hashes password using argon2id
 func Hash(password string) (string, error) {
 	salt, err := generateSalt()
 	if err != nil {
 		return "", err
 	}
 
-	dk, err := scrypt.Key([]byte(password), salt, n, r, p, k)
+	dk := argon2.IDKey([]byte(password), salt, argonTime, argonMemory, argonThreads, k)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix, argon2.Version, argonMemory, argonTime, argonThreads,
+		encodeBase64(salt), encodeBase64(dk),
+	), nil
+}
+
+// Compare compares hashed password with password,
+// hashed can be any supported format
+func Compare(hashed, password string) bool {
+	if strings.HasPrefix(hashed, argon2idPrefix) {
+		return compareArgon2id(hashed, password)
+	}
+	return compareScrypt(hashed, password)
+}
+
+// NeedsRehash returns true if hashed password was not hashed
+// using current algorithm and parameters
+func NeedsRehash(hashed string) bool {
+	if !strings.HasPrefix(hashed, argon2idPrefix) {
+		return true
+	}
+
+	v, m, t, p, _, dk := decodeArgon2id(hashed)
+	return v != argon2.Version || m < argonMemory || t < argonTime || p != argonThreads || len(dk) < k
+}
+
+func compareArgon2id(hashed, password string) bool {
+	v, m, t, p, salt, dk := decodeArgon2id(hashed)
+	if len(dk) == 0 || v != argon2.Version {
+		return false
+	}
+
+	pk := argon2.IDKey([]byte(password), salt, t, m, p, uint32(len(dk)))
+	return subtle.ConstantTimeCompare(dk, pk) == 1
+}
+
+func decodeArgon2id(hashed string) (v int, m, t uint32, p uint8, salt, dk []byte) {
+	xs := strings.Split(strings.TrimPrefix(hashed, argon2idPrefix), "$")
+	if len(xs) != 4 {
+		return
+	}
+
+	_, err := fmt.Sscanf(xs[0], "v=%d", &v)
 	if err != nil {
-		return "", err
+		return
 	}
-	return fmt.Sprintf("%d$%d$%d$%s$%s", n, r, p, encodeBase64(salt), encodeBase64(dk)), nil
+	_, err = fmt.Sscanf(xs[1], "m=%d,t=%d,p=%d", &m, &t, &p)
+	if err != nil {
+		return
+	}
+	salt = decodeBase64(xs[2])
+	dk = decodeBase64(xs[3])
+	return
 }
 
-func Compare(hashed, password string) bool {
-	n, r, p, salt, dk := decode(hashed)
+func compareScrypt(hashed, password string) bool {
+	n, r, p, salt, dk := decodeScrypt(hashed)
 	if len(dk) == 0 {
 		return false
 	}
@@ -46,7 +107,7 @@ func Compare(hashed, password string) bool {
 	return subtle.ConstantTimeCompare(dk, pk) == 1
 }
 
-func decode(hashed string) (n, r, p int, salt, dk []byte) {
+func decodeScrypt(hashed string) (n, r, p int, salt, dk []byte) {
 	xs := strings.Split(hashed, "$")
 	if len(xs) != 5 {
 		return
@@ -0,0 +1,110 @@
+package password
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// legacy scrypt hashes of "123456" with salt 0x00..0x0f, created by previous Hash
+const (
+	scryptHash      = "32768$8$1$AAECAwQFBgcICQoLDA0ODw$LJb7IPWvdQO9lJNqedPl4KBo7heZJfhGKLnKdTywGZ0"
+	scryptHashLight = "16384$8$1$AAECAwQFBgcICQoLDA0ODw$U+WfH59WwjF9mfeh52/R1DZ3zLGcLBJeBU+agSgeCuw"
+)
+
+func argon2idHash(v int, m, t uint32, p uint8, keyLen int) string {
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix, v, m, t, p,
+		encodeBase64(make([]byte, s)), encodeBase64(make([]byte, keyLen)),
+	)
+}
+
+func TestHash(t *testing.T) {
+	hashed, err := Hash("123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hashed, argon2idPrefix) {
+		t.Errorf("got %s; want argon2id hash", hashed)
+	}
+
+	other, err := Hash("123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hashed == other {
+		t.Errorf("same password hashed to same value; salt not random")
+	}
+}
+
+func TestCompare(t *testing.T) {
+	hashed, err := Hash("123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		Name     string
+		Hashed   string
+		Password string
+		Result   bool
+	}{
+		{"argon2id", hashed, "123456", true},
+		{"argon2id wrong password", hashed, "1234567", false},
+		{"argon2id empty password", hashed, "", false},
+		{"argon2id other version", strings.Replace(hashed, "v=19", "v=16", 1), "123456", false},
+		{"argon2id invalid key", hashed[:strings.LastIndex(hashed, "$")+1] + "!", "123456", false},
+		{"argon2id other key", hashed[:strings.LastIndex(hashed, "$")+1] + encodeBase64(make([]byte, k)), "123456", false},
+		{"argon2id missing part", strings.Join(strings.Split(hashed, "$")[:4], "$"), "123456", false},
+		{"scrypt", scryptHash, "123456", true},
+		{"scrypt other parameters", scryptHashLight, "123456", true},
+		{"scrypt wrong password", scryptHash, "1234567", false},
+		{"scrypt invalid n", "x" + scryptHash, "123456", false},
+		{"scrypt missing part", scryptHash[:strings.LastIndex(scryptHash, "$")], "123456", false},
+		{"empty", "", "", false},
+		{"garbage", "not a hash", "123456", false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			if got := Compare(tc.Hashed, tc.Password); got != tc.Result {
+				t.Errorf("got %v; want %v", got, tc.Result)
+			}
+		})
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	hashed, err := Hash("123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		Name   string
+		Hashed string
+		Result bool
+	}{
+		{"current", hashed, false},
+		{"current parameters", argon2idHash(argon2.Version, argonMemory, argonTime, argonThreads, k), false},
+		{"stronger memory and time", argon2idHash(argon2.Version, 2*argonMemory, argonTime+1, argonThreads, k), false},
+		{"longer key", argon2idHash(argon2.Version, argonMemory, argonTime, argonThreads, 2*k), false},
+		{"older version", argon2idHash(0x10, argonMemory, argonTime, argonThreads, k), true},
+		{"less memory", argon2idHash(argon2.Version, argonMemory/2, argonTime, argonThreads, k), true},
+		{"other threads", argon2idHash(argon2.Version, argonMemory, argonTime, argonThreads+1, k), true},
+		{"shorter key", argon2idHash(argon2.Version, argonMemory, argonTime, argonThreads, k/2), true},
+		{"invalid argon2id", argon2idPrefix + "invalid", true},
+		{"scrypt", scryptHash, true},
+		{"empty", "", true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			if got := NeedsRehash(tc.Hashed); got != tc.Result {
+				t.Errorf("got %v; want %v", got, tc.Result)
+			}
+		})
+	}
+}
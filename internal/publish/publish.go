@@ -0,0 +1,91 @@
+package publish
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/acoshift/pgsql/pgctx"
+
+	"github.com/acoshift/pikkanode/internal/config"
+	"github.com/acoshift/pikkanode/internal/feed"
+	"github.com/acoshift/pikkanode/internal/mention"
+)
+
+// Work statuses
+const (
+	Draft     = "draft"
+	Scheduled = "scheduled"
+	Published = "published"
+)
+
+const interval = time.Minute
+
+// Run publishes scheduled works which passed publish time, never returns
+func Run() {
+	ctx := pgctx.NewContext(context.Background(), config.DB())
+
+	for {
+		err := publishScheduled(ctx)
+		if err != nil {
+			log.Println("publish:", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+type publishedWork struct {
+	ID        int64
+	UserID    string
+	PublishAt time.Time
+}
+
+func publishScheduled(ctx context.Context) error {
+	// language=SQL
+	rows, err := pgctx.Query(ctx, `
+		update works
+		set status = $1
+		where id in (
+			select id
+			from works
+			where status = $2 and publish_at <= now()
+			order by publish_at
+			limit 100
+		)
+		returning id, user_id, publish_at
+	`, Published, Scheduled)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var xs []*publishedWork
+	for rows.Next() {
+		var x publishedWork
+		err := rows.Scan(&x.ID, &x.UserID, &x.PublishAt)
+		if err != nil {
+			return err
+		}
+		xs = append(xs, &x)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows.Close()
+
+	for _, x := range xs {
+		err := feed.AddWork(ctx, x.UserID, x.ID, x.PublishAt)
+		if err != nil {
+			log.Printf("publish: add work %d to feed; %v", x.ID, err)
+		}
+
+		err = mention.NotifyWork(ctx, strconv.FormatInt(x.ID, 10))
+		if err != nil {
+			log.Printf("publish: notify mentions of work %d; %v", x.ID, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package purge
+
+import (
+	"context"
+	"log"
+
+	"github.com/acoshift/pgsql/pgctx"
+	"github.com/lib/pq"
+
+	"github.com/acoshift/pikkanode/internal/file"
+)
+
+// QueueFiles queues stored files to be removed by purge,
+// call inside the transaction deleting rows referencing the files
+func QueueFiles(ctx context.Context, files ...string) error {
+	// language=SQL
+	_, err := pgctx.Exec(ctx, `
+		insert into file_removals (file)
+		select x
+		from unnest($1::varchar[]) as x
+		where x != ''
+		on conflict do nothing
+	`, pq.Array(files))
+	return err
+}
+
+// removeFiles removes queued files from storage,
+// failed file stays in queue and will be retried in next round
+func removeFiles(ctx context.Context) error {
+	// language=SQL
+	rows, err := pgctx.Query(ctx, `
+		select file
+		from file_removals
+		order by created_at
+		limit 1000
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var files []string
+	for rows.Next() {
+		var x string
+		err := rows.Scan(&x)
+		if err != nil {
+			return err
+		}
+		files = append(files, x)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows.Close()
+
+	for _, fn := range files {
+		// removing not exists file is not an error, safe to retry
+		err := file.Remove(ctx, fn)
+		if err != nil {
+			log.Printf("purge: remove file %s; %v", fn, err)
+			continue
+		}
+
+		// language=SQL
+		_, err = pgctx.Exec(ctx, `
+			delete from file_removals where file = $1
+		`, fn)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
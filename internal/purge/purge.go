@@ -0,0 +1,189 @@
+package purge
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/acoshift/pgsql/pgctx"
+
+	"github.com/acoshift/pikkanode/internal/config"
+	"github.com/acoshift/pikkanode/internal/session"
+)
+
+const interval = 10 * time.Minute
+
+// Run purges accounts which passed deletion grace period and expired data exports,
+// then removes their stored files, never returns
+func Run() {
+	ctx := pgctx.NewContext(context.Background(), config.DB())
+
+	for {
+		err := purgeUsers(ctx)
+		if err != nil {
+			log.Println("purge:", err)
+		}
+		err = purgeDataExports(ctx)
+		if err != nil {
+			log.Println("purge: data exports;", err)
+		}
+		err = removeFiles(ctx)
+		if err != nil {
+			log.Println("purge: files;", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+func purgeUsers(ctx context.Context) error {
+	// language=SQL
+	rows, err := pgctx.Query(ctx, `
+		select id
+		from users
+		where delete_at <= now()
+		limit 100
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var userIDs []string
+	for rows.Next() {
+		var id string
+		err := rows.Scan(&id)
+		if err != nil {
+			return err
+		}
+		userIDs = append(userIDs, id)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows.Close()
+
+	// failed user will be retried in next round
+	for _, id := range userIDs {
+		err := purgeUser(ctx, id)
+		if err != nil {
+			log.Printf("purge: user %s; %v", id, err)
+		}
+	}
+	return nil
+}
+
+// purgeUser deletes user and queues user's files for removal in the same transaction,
+// files are removed later by removeFiles so storage is not called while user locked
+func purgeUser(ctx context.Context, userID string) error {
+	purged := false
+	err := pgctx.RunInTx(ctx, func(ctx context.Context) error {
+		// lock user, deletion can not be cancelled while purging
+		var ok bool
+		// language=SQL
+		err := pgctx.QueryRow(ctx, `
+			select true
+			from users
+			where id = $1 and delete_at <= now()
+			for update
+		`, userID).Scan(&ok)
+		if err == sql.ErrNoRows {
+			// cancelled
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		files, err := getUserFiles(ctx, userID)
+		if err != nil {
+			return err
+		}
+		err = QueueFiles(ctx, files...)
+		if err != nil {
+			return err
+		}
+
+		// rows in other tables will be deleted by cascade
+		// language=SQL
+		_, err = pgctx.Exec(ctx, `
+			delete from users where id = $1
+		`, userID)
+		if err != nil {
+			return err
+		}
+
+		purged = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !purged {
+		return nil
+	}
+
+	err = session.RevokeAll(userID, "")
+	if err != nil {
+		log.Printf("purge: revoke sessions %s; %v", userID, err)
+	}
+
+	return nil
+}
+
+// getUserFiles returns all stored files owned by user
+func getUserFiles(ctx context.Context, userID string) ([]string, error) {
+	// language=SQL
+	rows, err := pgctx.Query(ctx, `
+		select photo from users where id = $1 and photo != ''
+		union all
+		select banner from users where id = $1 and banner != ''
+		union all
+		select photo from works where user_id = $1
+		union all
+		select original from works where user_id = $1 and original != ''
+		union all
+		select file from data_exports where user_id = $1
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var xs []string
+	for rows.Next() {
+		var x string
+		err := rows.Scan(&x)
+		if err != nil {
+			return nil, err
+		}
+		xs = append(xs, x)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return xs, nil
+}
+
+// purgeDataExports deletes expired data exports and queues their files for removal
+func purgeDataExports(ctx context.Context) error {
+	// language=SQL
+	_, err := pgctx.Exec(ctx, `
+		with d as (
+			delete from data_exports
+			where id in (
+				select id
+				from data_exports
+				where expires_at <= now()
+				limit 100
+			)
+			returning file
+		)
+		insert into file_removals (file)
+		select file from d
+		on conflict do nothing
+	`)
+	return err
+}
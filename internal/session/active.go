@@ -0,0 +1,304 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+
+	"github.com/acoshift/pikkanode/internal/config"
+)
+
+// Active sessions are indexed in redis per user,
+// the session cookie stays valid only while its record exists
+//
+//	{prefix}active:{sid} => hash of session info
+//	{prefix}user_sessions:{user_id} => set of sid
+
+var redisClient = config.RedisClient()
+
+// trustedProxies are proxies in front of the server, comma separated ip or cidr, ex.
+//
+//	trusted_proxies: 10.0.0.0/8,127.0.0.1
+var trustedProxies = loadTrustedProxies()
+
+func loadTrustedProxies() []*net.IPNet {
+	var xs []*net.IPNet
+	for x := range config.StringSet("trusted_proxies", nil) {
+		if !strings.Contains(x, "/") {
+			if strings.Contains(x, ":") {
+				x += "/128"
+			} else {
+				x += "/32"
+			}
+		}
+		_, n, err := net.ParseCIDR(x)
+		if err != nil {
+			log.Panicf("session: invalid trusted proxy %s; %v", x, err)
+		}
+		xs = append(xs, n)
+	}
+	return xs
+}
+
+func activeKey(sid string) string {
+	return config.RedisPrefix() + "active:" + sid
+}
+
+func userSessionsKey(userID string) string {
+	return config.RedisPrefix() + "user_sessions:" + userID
+}
+
+type requestInfoKey struct{}
+
+type requestInfo struct {
+	UserAgent string
+	IP        string
+}
+
+func activeMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), requestInfoKey{}, &requestInfo{
+			UserAgent: r.UserAgent(),
+			IP:        clientIP(r),
+		})
+		r = r.WithContext(ctx)
+
+		s := Get(ctx)
+		if userID := s.GetString("user_id"); userID != "" {
+			sid := s.GetString("sid")
+			if sid == "" {
+				// signed in before session tracking
+				recordActive(ctx, userID)
+			} else if !touchActive(sid) {
+				// revoked
+				s.Destroy()
+			}
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// clientIP returns the remote address, or when request comes from trusted proxy,
+// the right-most X-Forwarded-For hop not added by trusted proxies,
+// hops on the left are set by client and can not be trusted
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0 && isTrustedProxy(ip); i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			break
+		}
+		ip = hop
+	}
+	return ip
+}
+
+func isTrustedProxy(ip string) bool {
+	x := net.ParseIP(ip)
+	if x == nil {
+		return false
+	}
+	for _, n := range trustedProxies {
+		if n.Contains(x) {
+			return true
+		}
+	}
+	return false
+}
+
+// SignIn sets user id to session and records it as active session,
+// session id is regenerated to prevent session fixation
+func SignIn(ctx context.Context, userID string) {
+	s := Get(ctx)
+	if prevUserID, prevSID := s.GetString("user_id"), s.GetString("sid"); prevUserID != "" && prevSID != "" {
+		Revoke(prevUserID, prevSID)
+	}
+
+	s.Regenerate()
+	s.Set("user_id", userID)
+	recordActive(ctx, userID)
+}
+
+// SignOut destroys current session
+func SignOut(ctx context.Context) {
+	s := Get(ctx)
+	userID := s.GetString("user_id")
+	sid := s.GetString("sid")
+	s.Destroy()
+
+	if userID != "" && sid != "" {
+		Revoke(userID, sid)
+	}
+}
+
+// CurrentID returns current active session id
+func CurrentID(ctx context.Context) string {
+	return Get(ctx).GetString("sid")
+}
+
+func recordActive(ctx context.Context, userID string) {
+	sid := generateID()
+	Get(ctx).Set("sid", sid)
+
+	info, _ := ctx.Value(requestInfoKey{}).(*requestInfo)
+	if info == nil {
+		info = new(requestInfo)
+	}
+
+	now := time.Now().Unix()
+	redisClient.TxPipelined(func(tx redis.Pipeliner) error {
+		tx.HMSet(activeKey(sid), map[string]interface{}{
+			"user_id":      userID,
+			"user_agent":   info.UserAgent,
+			"ip":           info.IP,
+			"created_at":   now,
+			"last_seen_at": now,
+		})
+		tx.Expire(activeKey(sid), idleTimeout)
+		tx.SAdd(userSessionsKey(userID), sid)
+		tx.Expire(userSessionsKey(userID), maxAge)
+		return nil
+	})
+}
+
+// touchActive updates last seen time, returns false if session was revoked
+func touchActive(sid string) bool {
+	ok, err := redisClient.Expire(activeKey(sid), idleTimeout).Result()
+	if err != nil {
+		// redis unavailable, do not sign out everyone
+		return true
+	}
+	if !ok {
+		return false
+	}
+	redisClient.HSet(activeKey(sid), "last_seen_at", time.Now().Unix())
+	return true
+}
+
+type ActiveSession struct {
+	ID         string
+	Device     string
+	UserAgent  string
+	IP         string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+}
+
+// List lists user's active sessions, last seen first
+func List(userID string) ([]*ActiveSession, error) {
+	sids, err := redisClient.SMembers(userSessionsKey(userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	xs := make([]*ActiveSession, 0, len(sids))
+	for _, sid := range sids {
+		m, err := redisClient.HGetAll(activeKey(sid)).Result()
+		if err != nil {
+			return nil, err
+		}
+		if len(m) == 0 || m["user_id"] != userID {
+			// expired
+			redisClient.SRem(userSessionsKey(userID), sid)
+			continue
+		}
+
+		xs = append(xs, &ActiveSession{
+			ID:         sid,
+			Device:     device(m["user_agent"]),
+			UserAgent:  m["user_agent"],
+			IP:         m["ip"],
+			CreatedAt:  parseUnix(m["created_at"]),
+			LastSeenAt: parseUnix(m["last_seen_at"]),
+		})
+	}
+
+	sort.Slice(xs, func(i, j int) bool { return xs[i].LastSeenAt.After(xs[j].LastSeenAt) })
+	return xs, nil
+}
+
+// Revoke revokes user's session
+func Revoke(userID, sid string) error {
+	m, err := redisClient.HGetAll(activeKey(sid)).Result()
+	if err != nil {
+		return err
+	}
+	if len(m) > 0 && m["user_id"] != userID {
+		return nil
+	}
+
+	_, err = redisClient.TxPipelined(func(tx redis.Pipeliner) error {
+		tx.Del(activeKey(sid))
+		tx.SRem(userSessionsKey(userID), sid)
+		return nil
+	})
+	return err
+}
+
+// RevokeAll revokes all user's sessions except the given session id
+func RevokeAll(userID, exceptSID string) error {
+	sids, err := redisClient.SMembers(userSessionsKey(userID)).Result()
+	if err != nil {
+		return err
+	}
+
+	_, err = redisClient.TxPipelined(func(tx redis.Pipeliner) error {
+		for _, sid := range sids {
+			if sid == exceptSID {
+				continue
+			}
+			tx.Del(activeKey(sid))
+			tx.SRem(userSessionsKey(userID), sid)
+		}
+		return nil
+	})
+	return err
+}
+
+func generateID() string {
+	b := make([]byte, 24)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func parseUnix(s string) time.Time {
+	n, _ := strconv.ParseInt(s, 10, 64)
+	return time.Unix(n, 0)
+}
+
+// device returns short device description from user agent
+func device(ua string) string {
+	switch {
+	case strings.Contains(ua, "iPhone"):
+		return "iPhone"
+	case strings.Contains(ua, "iPad"):
+		return "iPad"
+	case strings.Contains(ua, "Android"):
+		return "Android"
+	case strings.Contains(ua, "Windows"):
+		return "Windows"
+	case strings.Contains(ua, "Macintosh"):
+		return "Mac"
+	case strings.Contains(ua, "Linux"):
+		return "Linux"
+	}
+	return "Unknown"
+}
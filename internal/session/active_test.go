@@ -0,0 +1,39 @@
+package session
+
+import (
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	_, n, _ := net.ParseCIDR("10.0.0.0/8")
+	trustedProxies = []*net.IPNet{n}
+	defer func() { trustedProxies = nil }()
+
+	cases := []struct {
+		RemoteAddr string
+		XFF        string
+		IP         string
+	}{
+		{"1.1.1.1:1234", "", "1.1.1.1"},
+		{"1.1.1.1:1234", "2.2.2.2", "1.1.1.1"},
+		{"10.0.0.1:1234", "", "10.0.0.1"},
+		{"10.0.0.1:1234", "2.2.2.2", "2.2.2.2"},
+		{"10.0.0.1:1234", "3.3.3.3, 2.2.2.2", "2.2.2.2"},
+		{"10.0.0.1:1234", "3.3.3.3, 2.2.2.2, 10.0.0.2", "2.2.2.2"},
+		{"10.0.0.1:1234", "10.0.0.3, 10.0.0.2", "10.0.0.3"},
+		{"10.0.0.1:1234", "not-ip, 10.0.0.2", "not-ip"},
+	}
+
+	for _, tc := range cases {
+		r, _ := http.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tc.RemoteAddr
+		if tc.XFF != "" {
+			r.Header.Set("X-Forwarded-For", tc.XFF)
+		}
+		if got := clientIP(r); got != tc.IP {
+			t.Errorf("remote %s, xff %q; got %s; want %s", tc.RemoteAddr, tc.XFF, got, tc.IP)
+		}
+	}
+}
@@ -11,20 +11,28 @@ import (
 	"github.com/acoshift/pikkanode/internal/config"
 )
 
+const (
+	idleTimeout = 7 * 24 * time.Hour
+	maxAge      = 30 * 24 * time.Hour
+)
+
 func Middleware() middleware.Middleware {
-	return session.Middleware(session.Config{
-		Secure:      session.PreferSecure,
-		IdleTimeout: 7 * 24 * time.Hour,
-		MaxAge:      30 * 24 * time.Hour,
-		Rolling:     true,
-		Proxy:       true,
-		Path:        "/",
-		HTTPOnly:    true,
-		Store: redisstore.New(redisstore.Config{
-			Prefix: config.RedisPrefix(),
-			Client: config.RedisClient(),
+	return middleware.Chain(
+		session.Middleware(session.Config{
+			Secure:      session.PreferSecure,
+			IdleTimeout: idleTimeout,
+			MaxAge:      maxAge,
+			Rolling:     true,
+			Proxy:       true,
+			Path:        "/",
+			HTTPOnly:    true,
+			Store: redisstore.New(redisstore.Config{
+				Prefix: config.RedisPrefix(),
+				Client: config.RedisClient(),
+			}),
 		}),
-	})
+		activeMiddleware,
+	)
 }
 
 const sessName = "s"
@@ -37,7 +45,49 @@ func Get(ctx context.Context) *session.Session {
 	return s
 }
 
+// GetUserID returns signed in user id,
+// for access token the token must has scope required by the context
 func GetUserID(ctx context.Context) string {
+	if t, ok := ctx.Value(tokenKey{}).(*tokenAuth); ok {
+		scope, _ := ctx.Value(scopeKey{}).(string)
+		if !t.allow(scope) {
+			return ""
+		}
+		return t.UserID
+	}
+
 	s := Get(ctx)
 	return s.GetString("user_id")
 }
+
+type tokenKey struct{}
+
+type scopeKey struct{}
+
+type tokenAuth struct {
+	UserID string
+	Scopes []string
+}
+
+// WithToken returns new context authenticated by access token,
+// session cookie will not be used for the context
+func WithToken(ctx context.Context, userID string, scopes []string) context.Context {
+	return context.WithValue(ctx, tokenKey{}, &tokenAuth{UserID: userID, Scopes: scopes})
+}
+
+// WithScope returns new context that requires scope from access token
+func WithScope(ctx context.Context, scope string) context.Context {
+	return context.WithValue(ctx, scopeKey{}, scope)
+}
+
+func (t *tokenAuth) allow(scope string) bool {
+	if scope == "" {
+		return false
+	}
+	for _, x := range t.Scopes {
+		if x == scope {
+			return true
+		}
+	}
+	return false
+}
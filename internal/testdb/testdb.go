@@ -0,0 +1,154 @@
+// Package testdb provides database for integration tests.
+//
+// Tests using the database are skipped when TEST_DB_DSN is not set, ex.
+//
+//	TEST_DB_DSN="postgres://localhost/pikkanode_test?sslmode=disable" go test ./...
+//
+// each test binary creates its own schema from table.sql,
+// the schema is dropped by Main after tests finished.
+package testdb
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/acoshift/pgsql/pgctx"
+	_ "github.com/lib/pq"
+
+	"github.com/acoshift/pikkanode/internal/session"
+)
+
+var (
+	dsn    = os.Getenv("TEST_DB_DSN")
+	schema = "test_" + randomHex(6)
+
+	setupOnce sync.Once
+	setupErr  error
+	db        *sql.DB
+)
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Main runs tests then drops test schema, call it from TestMain
+func Main(m *testing.M) {
+	code := m.Run()
+	if db != nil {
+		db.Exec(`drop schema ` + schema + ` cascade`)
+		db.Close()
+	}
+	os.Exit(code)
+}
+
+// Context returns context with test database,
+// the test is skipped when test database not configured
+func Context(t *testing.T) context.Context {
+	t.Helper()
+
+	if dsn == "" {
+		t.Skip("TEST_DB_DSN not set")
+	}
+	setupOnce.Do(func() {
+		setupErr = setup()
+	})
+	if setupErr != nil {
+		t.Fatal(setupErr)
+	}
+	return pgctx.NewContext(context.Background(), db)
+}
+
+func setup() error {
+	conn, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// extension is database-wide, can not create in every schema
+	_, err = conn.Exec(`create extension if not exists pgcrypto`)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Exec(`create schema ` + schema)
+	if err != nil {
+		return err
+	}
+
+	db, err = sql.Open("postgres", withSearchPath(dsn, schema+",public"))
+	if err != nil {
+		return err
+	}
+
+	_, file, _, _ := runtime.Caller(0)
+	b, err := ioutil.ReadFile(filepath.Join(filepath.Dir(file), "..", "..", "table.sql"))
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(strings.Replace(string(b), "create extension pgcrypto;", "", 1))
+	return err
+}
+
+// withSearchPath adds search_path runtime parameter to dsn
+func withSearchPath(dsn, searchPath string) string {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		sep := "?"
+		if strings.Contains(dsn, "?") {
+			sep = "&"
+		}
+		return dsn + sep + "search_path=" + url.QueryEscape(searchPath)
+	}
+	return dsn + " search_path=" + searchPath
+}
+
+// WithUser returns context authenticated as user,
+// empty user id for anonymous user
+func WithUser(ctx context.Context, userID string) context.Context {
+	const scope = "test"
+	ctx = session.WithToken(ctx, userID, []string{scope})
+	return session.WithScope(ctx, scope)
+}
+
+// CreateUser creates user with random username
+func CreateUser(ctx context.Context, t *testing.T) (userID, username string) {
+	t.Helper()
+
+	username = "u" + randomHex(6)
+	// language=SQL
+	err := pgctx.QueryRow(ctx, `
+		insert into users
+			(username, password)
+		values
+			($1, '')
+		returning id
+	`, username).Scan(&userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+// Exec executes sql, fails the test on error
+func Exec(ctx context.Context, t *testing.T, query string, args ...interface{}) {
+	t.Helper()
+
+	_, err := pgctx.Exec(ctx, query, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,131 @@
+package token
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/acoshift/middleware"
+	"github.com/acoshift/pgsql/pgctx"
+	"github.com/lib/pq"
+
+	"github.com/acoshift/pikkanode/internal/session"
+)
+
+// Scopes
+const (
+	ScopeRead   = "read"
+	ScopeWrite  = "write"
+	ScopeUpload = "upload"
+)
+
+const prefix = "pkn_"
+
+// lastUsedInterval is the precision of token's last used time
+const lastUsedInterval = "1 minute"
+
+func ValidScope(scope string) bool {
+	switch scope {
+	case ScopeRead, ScopeWrite, ScopeUpload:
+		return true
+	}
+	return false
+}
+
+// Generate generates new personal access token,
+// only hashed token should be stored
+func Generate() (token, hashed string, err error) {
+	b := make([]byte, 32)
+	_, err = rand.Read(b)
+	if err != nil {
+		return
+	}
+	token = prefix + base64.RawURLEncoding.EncodeToString(b)
+	hashed = Hash(token)
+	return
+}
+
+func Hash(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// Middleware authenticates request using Authorization: Bearer header
+func Middleware() middleware.Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			auth := r.Header.Get("Authorization")
+			if !strings.HasPrefix(auth, "Bearer ") {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			ctx := r.Context()
+			userID, scopes, err := lookup(ctx, strings.TrimSpace(strings.TrimPrefix(auth, "Bearer ")))
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+
+			// invalid token will result in no user
+			ctx = session.WithToken(ctx, userID, scopes)
+			h.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// Scope sets required scope for access token to the handler,
+// handler without scope can not be access by access token
+func Scope(scope string) middleware.Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := session.WithScope(r.Context(), scope)
+			h.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func lookup(ctx context.Context, token string) (userID string, scopes []string, err error) {
+	if !strings.HasPrefix(token, prefix) {
+		return "", nil, nil
+	}
+
+	var (
+		id    string
+		stale bool
+	)
+	// language=SQL
+	err = pgctx.QueryRow(ctx, `
+		select
+			id, user_id, scopes,
+			coalesce(last_used_at < now() - $2::interval, true)
+		from access_tokens
+		where token = $1
+	`, Hash(token), lastUsedInterval).Scan(&id, &userID, pq.Array(&scopes), &stale)
+	if err == sql.ErrNoRows {
+		return "", nil, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	// last used time is not exact, update only when stale to not write on every request
+	if stale {
+		// language=SQL
+		_, err = pgctx.Exec(ctx, `
+			update access_tokens
+			set last_used_at = now()
+			where id = $1
+		`, id)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	return userID, scopes, nil
+}
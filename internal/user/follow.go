@@ -0,0 +1,170 @@
+package user
+
+import (
+	"context"
+	"unicode/utf8"
+
+	"github.com/acoshift/pgsql/pgctx"
+
+	"github.com/acoshift/pikkanode/internal/file"
+	"github.com/acoshift/pikkanode/internal/paginate"
+	"github.com/acoshift/pikkanode/internal/session"
+	"github.com/acoshift/pikkanode/internal/validator"
+)
+
+type GetFollowsRequest struct {
+	Username string            `json:"username"`
+	Paginate paginate.Paginate `json:"paginate"`
+}
+
+func (req *GetFollowsRequest) Valid() error {
+	v := validator.New()
+	v.Must(req.Username != "", "username required")
+	v.Must(utf8.RuneCountInString(req.Username) <= 15, "username maximum 15 characters")
+
+	return v.Error()
+}
+
+type UserItem struct {
+	Username    string           `json:"username"`
+	DisplayName string           `json:"displayName"`
+	Photo       file.DownloadURL `json:"photo"`
+	Following   bool             `json:"following"`
+}
+
+type GetFollowsResult struct {
+	List     []*UserItem       `json:"list"`
+	Paginate paginate.Paginate `json:"paginate"`
+}
+
+// GetFollowers lists users who follow the user
+func GetFollowers(ctx context.Context, req *GetFollowsRequest) (*GetFollowsResult, error) {
+	userID := session.GetUserID(ctx)
+
+	targetID, err := getUserIDFromUsername(ctx, req.Username)
+	if err == errNotFound {
+		return nil, errUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	blocked, err := isBlocked(ctx, userID, targetID)
+	if err != nil {
+		return nil, err
+	}
+	if blocked {
+		return nil, errUserNotFound
+	}
+
+	{
+		err := req.Paginate.CountFrom(func() (cnt int64, err error) {
+			// language=SQL
+			err = pgctx.QueryRow(ctx, `
+				select count(*)
+				from follows
+				where following_id = $1
+				  and not is_blocked(user_id, nullif($2, '')::uuid)
+			`, targetID, userID).Scan(&cnt)
+			return
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// language=SQL
+	return getFollows(ctx, req, `
+		select
+			u.username, u.display_name, u.photo,
+			vf.user_id is not null
+		from follows f
+			left join users u on f.user_id = u.id
+			left join follows vf on vf.following_id = u.id and ($3 != '' and vf.user_id = $3::uuid)
+		where f.following_id = $4
+		  and not is_blocked(f.user_id, nullif($3, '')::uuid)
+		order by f.created_at desc
+		offset $1 limit $2
+	`, userID, targetID)
+}
+
+// GetFollowing lists users followed by the user
+func GetFollowing(ctx context.Context, req *GetFollowsRequest) (*GetFollowsResult, error) {
+	userID := session.GetUserID(ctx)
+
+	targetID, err := getUserIDFromUsername(ctx, req.Username)
+	if err == errNotFound {
+		return nil, errUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	blocked, err := isBlocked(ctx, userID, targetID)
+	if err != nil {
+		return nil, err
+	}
+	if blocked {
+		return nil, errUserNotFound
+	}
+
+	{
+		err := req.Paginate.CountFrom(func() (cnt int64, err error) {
+			// language=SQL
+			err = pgctx.QueryRow(ctx, `
+				select count(*)
+				from follows
+				where user_id = $1
+				  and not is_blocked(following_id, nullif($2, '')::uuid)
+			`, targetID, userID).Scan(&cnt)
+			return
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// language=SQL
+	return getFollows(ctx, req, `
+		select
+			u.username, u.display_name, u.photo,
+			vf.user_id is not null
+		from follows f
+			left join users u on f.following_id = u.id
+			left join follows vf on vf.following_id = u.id and ($3 != '' and vf.user_id = $3::uuid)
+		where f.user_id = $4
+		  and not is_blocked(f.following_id, nullif($3, '')::uuid)
+		order by f.created_at desc
+		offset $1 limit $2
+	`, userID, targetID)
+}
+
+func getFollows(ctx context.Context, req *GetFollowsRequest, query string, userID, targetID string) (*GetFollowsResult, error) {
+	rows, err := pgctx.Query(ctx, query, req.Paginate.Offset(), req.Paginate.Limit(), userID, targetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var r GetFollowsResult
+	r.List = make([]*UserItem, 0)
+	r.Paginate = req.Paginate
+
+	for rows.Next() {
+		var x UserItem
+		err := rows.Scan(
+			&x.Username, &x.DisplayName, &x.Photo,
+			&x.Following,
+		)
+		if err != nil {
+			return nil, err
+		}
+		r.List = append(r.List, &x)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
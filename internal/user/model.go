@@ -12,15 +12,38 @@ var (
 	errNotFound = errors.New("user: not found")
 )
 
+// getUserIDFromUsername returns user id from current username,
+// or from old username in username history
 func getUserIDFromUsername(ctx context.Context, username string) (userID string, err error) {
 	// language=SQL
 	err = pgctx.QueryRow(ctx, `
 		select id
-		from users
-		where username = $1
+		from (
+			select id, 0 as priority
+			from users
+			where lower(username) = lower($1)
+			union all
+			select user_id, 1
+			from username_history
+			where lower(username) = lower($1)
+		) t
+		order by priority
+		limit 1
 	`, username).Scan(&userID)
 	if err == sql.ErrNoRows {
 		return "", errNotFound
 	}
 	return
 }
+
+// isBlocked returns true if user and target blocked each other,
+// anonymous user never blocked
+func isBlocked(ctx context.Context, userID, targetID string) (blocked bool, err error) {
+	if userID == "" {
+		return false, nil
+	}
+
+	// language=SQL
+	err = pgctx.QueryRow(ctx, `select is_blocked($1, $2)`, userID, targetID).Scan(&blocked)
+	return
+}
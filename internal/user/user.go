@@ -9,7 +9,9 @@ import (
 
 	"github.com/acoshift/arpc"
 	"github.com/acoshift/pgsql/pgctx"
+	"github.com/lib/pq"
 
+	"github.com/acoshift/pikkanode/internal/feed"
 	"github.com/acoshift/pikkanode/internal/file"
 	"github.com/acoshift/pikkanode/internal/session"
 	"github.com/acoshift/pikkanode/internal/validator"
@@ -18,6 +20,7 @@ import (
 var (
 	errUserNotFound = arpc.NewError("user not found")
 	errFollowSelf   = arpc.NewError("can not follow self")
+	errFollowBlock  = arpc.NewError("can not follow this user")
 )
 
 type ProfileRequest struct {
@@ -47,25 +50,66 @@ func (req *ProfileRequest) Valid() error {
 }
 
 type ProfileResult struct {
-	Username  string           `json:"username"`
-	Photo     file.DownloadURL `json:"photo"`
-	Following bool             `json:"following"`
-	Follower  bool             `json:"follower"`
+	Username       string           `json:"username"`
+	DisplayName    string           `json:"displayName"`
+	Bio            string           `json:"bio"`
+	Links          []string         `json:"links"`
+	Location       string           `json:"location"`
+	Pronouns       string           `json:"pronouns"`
+	Photo          file.DownloadURL `json:"photo"`
+	Banner         file.DownloadURL `json:"banner"`
+	IsPrivate      bool             `json:"isPrivate"`
+	Following      bool             `json:"following"`
+	Requested      bool             `json:"requested"`
+	Follower       bool             `json:"follower"`
+	FollowerCount  int64            `json:"followerCount"`
+	FollowingCount int64            `json:"followingCount"`
+	WorkCount      int64            `json:"workCount"`
 }
 
 func Profile(ctx context.Context, req *ProfileRequest) (*ProfileResult, error) {
 	userID := session.GetUserID(ctx)
 
+	targetID, err := getUserIDFromUsername(ctx, req.Username)
+	if err == errNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	blocked, err := isBlocked(ctx, userID, targetID)
+	if err != nil {
+		return nil, err
+	}
+	if blocked {
+		return nil, nil
+	}
+
+	// username in result is the current username,
+	// may differ from requested username when user changed their username
 	var r ProfileResult
 	// language=SQL
-	err := pgctx.QueryRow(ctx, `
-		select username, photo,
-		       exists(select 1 from follows where following_id = users.id and user_id = $2),
-		       exists(select 1 from follows where user_id = users.id and following_id = $2)
+	err = pgctx.QueryRow(ctx, `
+		select username, display_name, bio, links, location, pronouns, photo, banner,
+		       is_private,
+		       exists(select 1 from follows where following_id = users.id and user_id = nullif($2, '')::uuid),
+		       exists(select 1 from follow_requests where following_id = users.id and user_id = nullif($2, '')::uuid),
+		       exists(select 1 from follows where user_id = users.id and following_id = nullif($2, '')::uuid),
+		       (select count(*) from follows where following_id = users.id),
+		       (select count(*) from follows where user_id = users.id),
+		       (select count(*) from works w
+		        where w.user_id = users.id
+		          and w.status = 'published'
+		          and w.visibility != 'unlisted'
+		          and can_view_work(w.user_id, w.visibility, w.status, nullif($2, '')::uuid))
 		from users
-		where username = $1
-	`, req.Username, userID).Scan(
-		&r.Username, &r.Photo, &r.Following, &r.Follower,
+		where id = $1
+	`, targetID, userID).Scan(
+		&r.Username, &r.DisplayName, &r.Bio, pq.Array(&r.Links), &r.Location, &r.Pronouns, &r.Photo, &r.Banner,
+		&r.IsPrivate,
+		&r.Following, &r.Requested, &r.Follower,
+		&r.FollowerCount, &r.FollowingCount, &r.WorkCount,
 	)
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -90,7 +134,20 @@ func (req *FollowRequest) Valid() error {
 	return v.Error()
 }
 
-func Follow(ctx context.Context, req *FollowRequest) (*struct{}, error) {
+// Follow states
+const (
+	FollowStateNone      = "none"
+	FollowStateFollowing = "following"
+	FollowStateRequested = "requested"
+)
+
+type FollowResult struct {
+	State string `json:"state"`
+}
+
+// Follow follows or unfollows user,
+// following private user creates follow request instead
+func Follow(ctx context.Context, req *FollowRequest) (*FollowResult, error) {
 	userID := session.GetUserID(ctx)
 	if userID == "" {
 		return nil, errInvalidCredentials
@@ -107,28 +164,92 @@ func Follow(ctx context.Context, req *FollowRequest) (*struct{}, error) {
 		return nil, errFollowSelf
 	}
 
-	if req.Follow {
+	if !req.Follow {
+		err := pgctx.RunInTx(ctx, func(ctx context.Context) error {
+			// language=SQL
+			_, err := pgctx.Exec(ctx, `
+				delete from follows
+				where user_id = $1 and following_id = $2
+			`, userID, followingID)
+			if err != nil {
+				return err
+			}
+
+			// language=SQL
+			_, err = pgctx.Exec(ctx, `
+				delete from follow_requests
+				where user_id = $1 and following_id = $2
+			`, userID, followingID)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		err = feed.Unfollow(ctx, userID, followingID)
+		if err != nil {
+			return nil, err
+		}
+
+		return &FollowResult{State: FollowStateNone}, nil
+	}
+
+	blocked, err := isBlocked(ctx, userID, followingID)
+	if err != nil {
+		return nil, err
+	}
+	if blocked {
+		return nil, errFollowBlock
+	}
+
+	var isPrivate, following bool
+	// language=SQL
+	err = pgctx.QueryRow(ctx, `
+		select
+			is_private,
+			exists(select 1 from follows where user_id = $2 and following_id = users.id)
+		from users
+		where id = $1
+	`, followingID, userID).Scan(&isPrivate, &following)
+	if err != nil {
+		return nil, err
+	}
+	if following {
+		return &FollowResult{State: FollowStateFollowing}, nil
+	}
+
+	if isPrivate {
 		// language=SQL
-		_, err := pgctx.Exec(ctx, `
-			insert into follows
+		_, err = pgctx.Exec(ctx, `
+			insert into follow_requests
 				(user_id, following_id)
 			values
 				($1, $2)
 			on conflict (user_id, following_id) do nothing
 		`, userID, followingID)
 		if err != nil {
-			return &struct{}{}, err
-		}
-	} else {
-		// language=SQL
-		_, err := pgctx.Exec(ctx, `
-			delete from follows
-			where user_id = $1 and following_id = $2
-		`, userID, followingID)
-		if err != nil {
-			return &struct{}{}, err
+			return nil, err
 		}
+
+		return &FollowResult{State: FollowStateRequested}, nil
+	}
+
+	// language=SQL
+	_, err = pgctx.Exec(ctx, `
+		insert into follows
+			(user_id, following_id)
+		values
+			($1, $2)
+		on conflict (user_id, following_id) do nothing
+	`, userID, followingID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = feed.Follow(ctx, userID, followingID)
+	if err != nil {
+		return nil, err
 	}
 
-	return new(struct{}), nil
+	return &FollowResult{State: FollowStateFollowing}, nil
 }
@@ -0,0 +1,119 @@
+package user
+
+import (
+	"context"
+	"unicode/utf8"
+
+	"github.com/acoshift/pgsql/pgctx"
+	"github.com/lib/pq"
+
+	"github.com/acoshift/pikkanode/internal/discovery"
+	"github.com/acoshift/pikkanode/internal/paginate"
+	"github.com/acoshift/pikkanode/internal/session"
+	"github.com/acoshift/pikkanode/internal/validator"
+)
+
+type GetWorksRequest struct {
+	Username string            `json:"username"`
+	Tag      string            `json:"tag"`
+	Paginate paginate.Paginate `json:"paginate"`
+}
+
+func (req *GetWorksRequest) Valid() error {
+	v := validator.New()
+	v.Must(req.Username != "", "username required")
+	v.Must(utf8.RuneCountInString(req.Username) <= 15, "username maximum 15 characters")
+	v.Must(req.Tag == "" || validator.IsTag(req.Tag), "invalid tag")
+
+	return v.Error()
+}
+
+type GetWorksResult struct {
+	List     []*discovery.WorkItem `json:"list"`
+	Paginate paginate.Paginate     `json:"paginate"`
+}
+
+func GetWorks(ctx context.Context, req *GetWorksRequest) (*GetWorksResult, error) {
+	userID := session.GetUserID(ctx)
+
+	targetID, err := getUserIDFromUsername(ctx, req.Username)
+	if err == errNotFound {
+		return nil, errUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	blocked, err := isBlocked(ctx, userID, targetID)
+	if err != nil {
+		return nil, err
+	}
+	if blocked {
+		return nil, errUserNotFound
+	}
+
+	var r GetWorksResult
+	{
+		err := req.Paginate.CountFrom(func() (cnt int64, err error) {
+			// language=SQL
+			err = pgctx.QueryRow(ctx, `
+				select count(*)
+				from works
+				where user_id = $1 and ($2 = '' or $2 = any(tags))
+				  and status = 'published'
+				  and visibility != 'unlisted'
+				  and can_view_work(user_id, visibility, status, nullif($3, '')::uuid)
+			`, targetID, req.Tag, userID).Scan(&cnt)
+			return
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	{
+		// language=SQL
+		rows, err := pgctx.Query(ctx, `
+			select
+				w.id, w.name, w.detail, w.photo, w.tags, w.created_at, w.publish_at, w.pin_order is not null,
+				w.favorite_count, w.comment_count,
+				f.work_id is not null as is_favorite
+			from works w
+				left join favorites f on w.id = f.work_id and ($3 != '' and f.user_id = $3::uuid)
+			where w.user_id = $4 and ($5 = '' or $5 = any(w.tags))
+			  and w.status = 'published'
+			  and w.visibility != 'unlisted'
+			  and can_view_work(w.user_id, w.visibility, w.status, nullif($3, '')::uuid)
+			order by w.pin_order nulls last, w.publish_at desc, w.id desc
+			offset $1 limit $2
+		`, req.Paginate.Offset(), req.Paginate.Limit(), userID, targetID, req.Tag)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		r.List = make([]*discovery.WorkItem, 0)
+		r.Paginate = req.Paginate
+
+		for rows.Next() {
+			var x discovery.WorkItem
+			err := rows.Scan(
+				&x.ID, &x.Name, &x.Detail, &x.Photo, pq.Array(&x.Tags), &x.CreatedAt, &x.PublishAt, &x.IsPinned,
+				&x.FavoriteCount, &x.CommentCount,
+				&x.IsFavorite,
+			)
+			if err != nil {
+				return nil, err
+			}
+			r.List = append(r.List, &x)
+		}
+
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+
+		rows.Close()
+	}
+
+	return &r, nil
+}
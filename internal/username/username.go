@@ -0,0 +1,116 @@
+package username
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/acoshift/pgsql/pgctx"
+
+	"github.com/acoshift/pikkanode/internal/config"
+)
+
+// Length limits
+const (
+	MinLength = 6
+	MaxLength = 15
+)
+
+var re = regexp.MustCompile(`^[a-zA-Z0-9]*$`)
+
+// defaultReserved contains words can be used to impersonate staff and route names,
+// username containing any of them is not allowed, ex. admin1, adminteam, pikkanodeofficial.
+// Route names shorter than MinLength (ex. me, api) can not be username, so not listed.
+var defaultReserved = []string{
+	"admin", "staff", "moderator", "official", "support", "security", "system", "pikkanode",
+	"discovery", "healthz", "signin", "signup", "signout", "logout", "settings",
+}
+
+// defaultBlocked contains offensive words, username containing any of them is not allowed.
+//
+// Usernames have no word boundary, so words are matched as substring and
+// innocent names containing them are blocked too (Scunthorpe problem),
+// ex. therapist contains rapist. Known innocent names are listed in defaultAllowed.
+var defaultBlocked = []string{
+	"fuck", "shit", "cunt", "bitch", "whore", "slut", "penis", "vagina",
+	"porn", "nigger", "nigga", "faggot", "retard", "rapist", "nazi", "hitler",
+}
+
+// defaultAllowed contains innocent words containing blocked words,
+// they are removed from username before checking blocked words
+var defaultAllowed = []string{
+	"scunthorpe", "therapist", "nazir", "nazim", "penistone", "shitake", "shiitake",
+}
+
+// confusables folds look-alike characters, ex. adm1n and pikkan0de are matched as reserved
+var confusables = strings.NewReplacer(
+	"0", "o", "1", "i", "l", "i", "3", "e", "4", "a", "5", "s", "7", "t",
+)
+
+func fold(s string) string {
+	return confusables.Replace(strings.ToLower(s))
+}
+
+func foldList(name string, def []string) []string {
+	var xs []string
+	for x := range config.StringSet(name, def) {
+		xs = append(xs, fold(x))
+	}
+	return xs
+}
+
+var (
+	reserved = foldList("reserved_usernames", defaultReserved)
+	blocked  = foldList("blocked_username_words", defaultBlocked)
+	allowed  = foldList("allowed_username_words", defaultAllowed)
+)
+
+// Valid checks username characters and length
+func Valid(s string) bool {
+	n := utf8.RuneCountInString(s)
+	return n >= MinLength && n <= MaxLength && re.MatchString(s)
+}
+
+// ValidChar checks is c allowed in username
+func ValidChar(c rune) bool {
+	return re.MatchString(string(c))
+}
+
+// Allowed checks username not contains reserved or blocked word,
+// words are compared after folding look-alike characters
+func Allowed(s string) bool {
+	s = fold(s)
+	for _, w := range reserved {
+		if strings.Contains(s, w) {
+			return false
+		}
+	}
+
+	// separator keeps remaining parts from joining into blocked word
+	for _, w := range allowed {
+		s = strings.Replace(s, w, "-", -1)
+	}
+	for _, w := range blocked {
+		if strings.Contains(s, w) {
+			return false
+		}
+	}
+	return true
+}
+
+// Held checks is username held by username history of other user than userID
+func Held(ctx context.Context, name string, userID string) (held bool, err error) {
+	// language=SQL
+	err = pgctx.QueryRow(ctx, `
+		select exists(
+			select 1
+			from username_history
+			where lower(username) = lower($1)
+			  and user_id::text != $2
+			  and created_at > now() - $3 * interval '1 second'
+		)
+	`, name, userID, int64(config.UsernameHoldPeriod()/time.Second)).Scan(&held)
+	return
+}
@@ -0,0 +1,82 @@
+package username_test
+
+import (
+	"testing"
+
+	"github.com/acoshift/pikkanode/internal/username"
+)
+
+func TestValid(t *testing.T) {
+	cases := []struct {
+		Username string
+		Result   bool
+	}{
+		{"tester", true},
+		{"Tester123", true},
+		{"123456", true},
+		{"abcdefghijklmno", true},
+		{"", false},
+		{"abcde", false},
+		{"abcdefghijklmnop", false},
+		{"test_er", false},
+		{"test.er", false},
+		{"test er", false},
+		{"tester!", false},
+		{"ทดสอบทดสอบ", false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Username, func(t *testing.T) {
+			if got := username.Valid(tc.Username); got != tc.Result {
+				t.Errorf("got %v; want %v", got, tc.Result)
+			}
+		})
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	cases := []struct {
+		Username string
+		Result   bool
+	}{
+		{"tester", true},
+		{"catlover99", true},
+		{"pikka", true},
+
+		// reserved
+		{"admin1", false},
+		{"adminteam", false},
+		{"theadmin", false},
+		{"ADMIN99", false},
+		{"administrator", false},
+		{"pikkanode", false},
+		{"pikkanodeofficial", false},
+		{"settings", false},
+
+		// look-alike characters
+		{"adm1n99", false},
+		{"admln99", false},
+		{"pikkan0de", false},
+		{"5upport", false},
+
+		// blocked words
+		{"fuckyou", false},
+		{"FuCkYoU", false},
+		{"sh1tpost", false},
+		{"therapist1", true},
+		{"scunthorpe", true},
+		{"nazir1990", true},
+		{"therapistfuck", false},
+		{"rapist", false},
+		{"nazi1990", false},
+		{"fuscunthorpek", true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Username, func(t *testing.T) {
+			if got := username.Allowed(tc.Username); got != tc.Result {
+				t.Errorf("got %v; want %v", got, tc.Result)
+			}
+		})
+	}
+}
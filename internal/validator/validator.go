@@ -1,6 +1,7 @@
 package validator
 
 import (
+	"net/url"
 	"regexp"
 
 	"github.com/acoshift/arpc"
@@ -24,3 +25,12 @@ var rxTag = regexp.MustCompile(`^[\w\-]+$`)
 func IsTag(str string) bool {
 	return rxTag.MatchString(str)
 }
+
+// IsWebURL checks is str an absolute http or https url
+func IsWebURL(str string) bool {
+	u, err := url.Parse(str)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
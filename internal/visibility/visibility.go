@@ -0,0 +1,18 @@
+package visibility
+
+// Work visibilities
+const (
+	Public    = "public"
+	Unlisted  = "unlisted"  // viewable by direct link, not listed
+	Followers = "followers" // viewable only by followers
+	Private   = "private"   // viewable only by owner
+)
+
+// Valid returns true if v is a valid visibility
+func Valid(v string) bool {
+	switch v {
+	case Public, Unlisted, Followers, Private:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,528 @@
+package work
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/acoshift/pgsql/pgctx"
+	"github.com/asaskevich/govalidator"
+
+	"github.com/acoshift/pikkanode/internal/config"
+	"github.com/acoshift/pikkanode/internal/mention"
+	"github.com/acoshift/pikkanode/internal/paginate"
+	"github.com/acoshift/pikkanode/internal/session"
+	"github.com/acoshift/pikkanode/internal/validator"
+)
+
+// Comment sorts
+const (
+	SortNewest = "newest"
+	SortOldest = "oldest"
+	SortTop    = "top" // most replies first
+)
+
+const (
+	defaultCommentLimit = 20
+	maxCommentLimit     = 100
+)
+
+type GetCommentsRequest struct {
+	ID     string `json:"id"`     // work id
+	Sort   string `json:"sort"`   // newest (default), oldest or top
+	Cursor string `json:"cursor"` // empty for first page
+	Limit  int    `json:"limit"`
+
+	cursor commentCursor
+}
+
+func (req *GetCommentsRequest) Valid() error {
+	if req.Sort == "" {
+		req.Sort = SortNewest
+	}
+	if req.Limit <= 0 {
+		req.Limit = defaultCommentLimit
+	}
+
+	v := validator.New()
+	v.Must(req.ID != "", "id required")
+	v.Must(govalidator.IsNumeric(req.ID), "invalid id")
+	v.Must(req.Sort == SortNewest || req.Sort == SortOldest || req.Sort == SortTop, "invalid sort")
+	v.Must(req.Limit <= maxCommentLimit, "limit maximum 100")
+	if req.Cursor != "" {
+		var err error
+		req.cursor, err = decodeCommentCursor(req.Cursor)
+		v.Must(err == nil, "invalid cursor")
+	}
+
+	return v.Error()
+}
+
+type GetCommentsResult struct {
+	List       []*CommentItem `json:"list"`
+	NextCursor string         `json:"nextCursor"` // empty when no more comments
+}
+
+// GetComments lists work's top-level comments
+func GetComments(ctx context.Context, req *GetCommentsRequest) (*GetCommentsResult, error) {
+	userID := session.GetUserID(ctx)
+
+	ok, err := canViewWork(ctx, req.ID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errWorkNotFound
+	}
+
+	var r GetCommentsResult
+	r.List, r.NextCursor, err = getComments(ctx, &commentsQuery{
+		WorkID: req.ID,
+		UserID: userID,
+		Sort:   req.Sort,
+		Cursor: req.cursor,
+		Limit:  req.Limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// commentCursor is the position of the last comment in page,
+// newest and oldest sorts use keyset over (created_at, id),
+// top sort uses offset since reply counts keep changing
+type commentCursor struct {
+	CreatedAt *time.Time
+	ID        string
+	Offset    int
+}
+
+func (c commentCursor) encode() string {
+	var s string
+	if c.CreatedAt != nil {
+		s = strconv.FormatInt(c.CreatedAt.UnixNano(), 10) + ":" + c.ID
+	} else {
+		s = strconv.Itoa(c.Offset)
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+func decodeCommentCursor(s string) (c commentCursor, err error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return
+	}
+
+	xs := strings.SplitN(string(b), ":", 2)
+	if len(xs) == 1 {
+		c.Offset, err = strconv.Atoi(xs[0])
+		return
+	}
+
+	n, err := strconv.ParseInt(xs[0], 10, 64)
+	if err != nil {
+		return
+	}
+	if !govalidator.IsUUID(xs[1]) {
+		err = strconv.ErrSyntax
+		return
+	}
+	t := time.Unix(0, n).UTC()
+	c.CreatedAt = &t
+	c.ID = xs[1]
+	return
+}
+
+type commentsQuery struct {
+	WorkID string
+	UserID string
+	Sort   string
+	Cursor commentCursor
+	Limit  int
+}
+
+// language=SQL
+const commentsSelect = `
+	select
+		c.id, c.content, comment_mentions(c.id), c.created_at, c.edited_at, c.hidden_at is not null, c.deleted_at is not null,
+		(
+			select count(*)
+			from comments r
+			where r.parent_id = c.id and r.deleted_at is null
+			  and can_view_comment(r, w.user_id, nullif($2, '')::uuid)
+		) as reply_count,
+		coalesce(u.username, ''), coalesce(u.photo, ''),
+		comment_reactions(c.id),
+		coalesce((select reaction from reactions where comment_id = c.id and user_id = nullif($2, '')::uuid), '')
+	from comments c
+		join works w on c.work_id = w.id
+		left join users u on c.user_id = u.id and c.deleted_at is null
+	where c.work_id = $1 and c.parent_id is null
+	  and can_view_comment(c, w.user_id, nullif($2, '')::uuid)
+	  and (c.deleted_at is null or exists(
+		select 1
+		from comments r
+		where r.parent_id = c.id and r.deleted_at is null
+		  and can_view_comment(r, w.user_id, nullif($2, '')::uuid)
+	  ))
+`
+
+func getComments(ctx context.Context, q *commentsQuery) ([]*CommentItem, string, error) {
+	// uuid parameter can not be empty string
+	var cursorID interface{}
+	if q.Cursor.ID != "" {
+		cursorID = q.Cursor.ID
+	}
+
+	// fetch one more comment to know whether next page exists
+	var (
+		rows *sql.Rows
+		err  error
+	)
+	switch q.Sort {
+	case SortOldest:
+		// language=SQL
+		rows, err = pgctx.Query(ctx, commentsSelect+`
+			and ($3::timestamp is null or (c.created_at, c.id) > ($3, $4::uuid))
+			order by c.created_at, c.id
+			limit $5
+		`, q.WorkID, q.UserID, q.Cursor.CreatedAt, cursorID, q.Limit+1)
+	case SortTop:
+		// language=SQL
+		rows, err = pgctx.Query(ctx, commentsSelect+`
+			order by reply_count desc, c.created_at desc, c.id desc
+			offset $3 limit $4
+		`, q.WorkID, q.UserID, q.Cursor.Offset, q.Limit+1)
+	default:
+		// language=SQL
+		rows, err = pgctx.Query(ctx, commentsSelect+`
+			and ($3::timestamp is null or (c.created_at, c.id) < ($3, $4::uuid))
+			order by c.created_at desc, c.id desc
+			limit $5
+		`, q.WorkID, q.UserID, q.Cursor.CreatedAt, cursorID, q.Limit+1)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	xs := make([]*CommentItem, 0)
+	for rows.Next() {
+		var x CommentItem
+		err := rows.Scan(
+			&x.ID, &x.Content, &x.Mentions, &x.CreatedAt, &x.EditedAt, &x.IsHidden, &x.IsDeleted,
+			&x.ReplyCount,
+			&x.User.Username, &x.User.Photo,
+			&x.Reactions, &x.MyReaction,
+		)
+		if err != nil {
+			return nil, "", err
+		}
+		xs = append(xs, &x)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	if len(xs) <= q.Limit {
+		return xs, "", nil
+	}
+	xs = xs[:q.Limit]
+
+	var next commentCursor
+	if q.Sort == SortTop {
+		next.Offset = q.Cursor.Offset + q.Limit
+	} else {
+		last := xs[len(xs)-1]
+		next.CreatedAt = &last.CreatedAt
+		next.ID = last.ID
+	}
+	return xs, next.encode(), nil
+}
+
+type GetRepliesRequest struct {
+	ID       string            `json:"id"` // comment id
+	Paginate paginate.Paginate `json:"paginate"`
+}
+
+func (req *GetRepliesRequest) Valid() error {
+	v := validator.New()
+	v.Must(req.ID != "", "id required")
+	v.Must(govalidator.IsUUID(req.ID), "invalid id")
+
+	return v.Error()
+}
+
+type GetRepliesResult struct {
+	List     []*CommentItem    `json:"list"`
+	Paginate paginate.Paginate `json:"paginate"`
+}
+
+// GetReplies lists replies of a top-level comment, oldest first
+func GetReplies(ctx context.Context, req *GetRepliesRequest) (*GetRepliesResult, error) {
+	userID := session.GetUserID(ctx)
+
+	{
+		var workID string
+		// language=SQL
+		err := pgctx.QueryRow(ctx, `
+			select c.work_id
+			from comments c
+				join works w on c.work_id = w.id
+			where c.id = $1 and c.parent_id is null
+			  and can_view_comment(c, w.user_id, nullif($2, '')::uuid)
+			  and can_view_work(w.user_id, w.visibility, w.status, nullif($2, '')::uuid)
+		`, req.ID, userID).Scan(&workID)
+		if err == sql.ErrNoRows {
+			return nil, errCommentNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var r GetRepliesResult
+	{
+		err := req.Paginate.CountFrom(func() (cnt int64, err error) {
+			// language=SQL
+			err = pgctx.QueryRow(ctx, `
+				select count(*)
+				from comments c
+					join works w on c.work_id = w.id
+				where c.parent_id = $1 and c.deleted_at is null
+				  and can_view_comment(c, w.user_id, nullif($2, '')::uuid)
+			`, req.ID, userID).Scan(&cnt)
+			return
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	{
+		// language=SQL
+		rows, err := pgctx.Query(ctx, `
+			select
+				c.id, c.content, comment_mentions(c.id), c.created_at, c.edited_at, c.hidden_at is not null,
+				u.username, u.photo,
+				comment_reactions(c.id),
+				coalesce((select reaction from reactions where comment_id = c.id and user_id = nullif($3, '')::uuid), '')
+			from comments c
+				join works w on c.work_id = w.id
+				left join users u on c.user_id = u.id
+			where c.parent_id = $4 and c.deleted_at is null
+			  and can_view_comment(c, w.user_id, nullif($3, '')::uuid)
+			order by c.created_at, c.id
+			offset $1 limit $2
+		`, req.Paginate.Offset(), req.Paginate.Limit(), userID, req.ID)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		r.List = make([]*CommentItem, 0)
+		r.Paginate = req.Paginate
+
+		for rows.Next() {
+			var x CommentItem
+			err := rows.Scan(
+				&x.ID, &x.Content, &x.Mentions, &x.CreatedAt, &x.EditedAt, &x.IsHidden,
+				&x.User.Username, &x.User.Photo,
+				&x.Reactions, &x.MyReaction,
+			)
+			if err != nil {
+				return nil, err
+			}
+			r.List = append(r.List, &x)
+		}
+
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+
+		rows.Close()
+	}
+
+	return &r, nil
+}
+
+type EditCommentRequest struct {
+	ID      string `json:"id"`
+	Content string `json:"content"`
+}
+
+func (req *EditCommentRequest) Valid() error {
+	v := validator.New()
+	v.Must(req.ID != "", "id required")
+	v.Must(govalidator.IsUUID(req.ID), "invalid id")
+	v.Must(req.Content != "", "content required")
+	v.Must(utf8.RuneCountInString(req.Content) <= 255, "content length maximum 255 characters")
+
+	return v.Error()
+}
+
+// EditComment edits my comment within edit window, returns the edited comment
+func EditComment(ctx context.Context, req *EditCommentRequest) (*CommentItem, error) {
+	userID := session.GetUserID(ctx)
+	if userID == "" {
+		return nil, errInvalidCredentials
+	}
+
+	var createdAt time.Time
+	// language=SQL
+	err := pgctx.QueryRow(ctx, `
+		select created_at
+		from comments
+		where id = $1 and user_id = $2 and deleted_at is null
+	`, req.ID, userID).Scan(&createdAt)
+	if err == sql.ErrNoRows {
+		return nil, errCommentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if time.Since(createdAt) > config.CommentEditWindow() {
+		return nil, errCommentEditWindowPassed
+	}
+
+	var r CommentItem
+	err = pgctx.RunInTx(ctx, func(ctx context.Context) error {
+		// language=SQL
+		err := pgctx.QueryRow(ctx, `
+			with c as (
+				update comments
+				set content = $3,
+				    edited_at = now()
+				where id = $1 and user_id = $2 and deleted_at is null
+				returning id, work_id, user_id, content, created_at, edited_at, hidden_at
+			)
+			select
+				c.id, c.content, c.created_at, c.edited_at, c.hidden_at is not null,
+				(
+					select count(*)
+					from comments r
+					where r.parent_id = c.id and r.deleted_at is null
+					  and can_view_comment(r, w.user_id, $2)
+				),
+				u.username, u.photo,
+				comment_reactions(c.id),
+				coalesce((select reaction from reactions where comment_id = c.id and user_id = $2), '')
+			from c
+				join works w on c.work_id = w.id
+				left join users u on c.user_id = u.id
+		`, req.ID, userID, req.Content).Scan(
+			&r.ID, &r.Content, &r.CreatedAt, &r.EditedAt, &r.IsHidden,
+			&r.ReplyCount,
+			&r.User.Username, &r.User.Photo,
+			&r.Reactions, &r.MyReaction,
+		)
+		if err == sql.ErrNoRows {
+			// deleted
+			return errCommentNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		r.Mentions, err = mention.SetComment(ctx, userID, r.ID, r.Content)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+type DeleteCommentRequest struct {
+	ID string `json:"id"`
+}
+
+func (req *DeleteCommentRequest) Valid() error {
+	v := validator.New()
+	v.Must(req.ID != "", "id required")
+	v.Must(govalidator.IsUUID(req.ID), "invalid id")
+
+	return v.Error()
+}
+
+// DeleteComment deletes my comment, or any comment on my work,
+// deleted comment with replies remains as a placeholder
+func DeleteComment(ctx context.Context, req *DeleteCommentRequest) (*struct{}, error) {
+	userID := session.GetUserID(ctx)
+	if userID == "" {
+		return nil, errInvalidCredentials
+	}
+
+	err := pgctx.RunInTx(ctx, func(ctx context.Context) error {
+		// language=SQL
+		res, err := pgctx.Exec(ctx, `
+			update comments c
+			set content = '',
+			    deleted_at = now()
+			from works w
+			where c.id = $1 and c.work_id = w.id and c.deleted_at is null
+			  and $2 in (c.user_id, w.user_id)
+		`, req.ID, userID)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return errCommentNotFound
+		}
+
+		// content is cleared, remove its mentions
+		_, err = mention.SetComment(ctx, userID, req.ID, "")
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return new(struct{}), nil
+}
+
+type HideCommentRequest struct {
+	ID   string `json:"id"`
+	Hide bool   `json:"hide"`
+}
+
+func (req *HideCommentRequest) Valid() error {
+	v := validator.New()
+	v.Must(req.ID != "", "id required")
+	v.Must(govalidator.IsUUID(req.ID), "invalid id")
+
+	return v.Error()
+}
+
+// HideComment hides or unhides comment on my work,
+// hidden comment is visible only to its author and me
+func HideComment(ctx context.Context, req *HideCommentRequest) (*struct{}, error) {
+	userID := session.GetUserID(ctx)
+	if userID == "" {
+		return nil, errInvalidCredentials
+	}
+
+	// language=SQL
+	res, err := pgctx.Exec(ctx, `
+		update comments c
+		set hidden_at = case when $3 then coalesce(c.hidden_at, now()) end
+		from works w
+		where c.id = $1 and c.work_id = w.id and c.deleted_at is null
+		  and w.user_id = $2
+	`, req.ID, userID, req.Hide)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, errCommentNotFound
+	}
+
+	return new(struct{}), nil
+}
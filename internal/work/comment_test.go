@@ -0,0 +1,126 @@
+package work_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/acoshift/pgsql/pgctx"
+
+	"github.com/acoshift/pikkanode/internal/testdb"
+	"github.com/acoshift/pikkanode/internal/work"
+)
+
+func TestHiddenComment(t *testing.T) {
+	ctx := testdb.Context(t)
+
+	ownerID, _ := testdb.CreateUser(ctx, t)
+	authorID, _ := testdb.CreateUser(ctx, t)
+	replierID, _ := testdb.CreateUser(ctx, t)
+	viewerID, _ := testdb.CreateUser(ctx, t)
+
+	var workID string
+	// language=SQL
+	err := pgctx.QueryRow(ctx, `
+		insert into works
+			(user_id, name, photo)
+		values
+			($1, 'work', '')
+		returning id
+	`, ownerID).Scan(&workID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ownerCtx := testdb.WithUser(ctx, ownerID)
+	authorCtx := testdb.WithUser(ctx, authorID)
+	replierCtx := testdb.WithUser(ctx, replierID)
+	viewerCtx := testdb.WithUser(ctx, viewerID)
+
+	comment := func(ctx context.Context, parentID string) string {
+		t.Helper()
+
+		c, err := work.PostComment(ctx, &work.CommentRequest{ID: workID, ParentID: parentID, Content: "comment"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c.ID
+	}
+
+	hide := func(id string) {
+		t.Helper()
+
+		_, err := work.HideComment(ownerCtx, &work.HideCommentRequest{ID: id, Hide: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("reply to hidden comment", func(t *testing.T) {
+		parentID := comment(authorCtx, "")
+		replyID := comment(replierCtx, parentID)
+		hide(parentID)
+
+		cases := []struct {
+			Viewer string
+			Ctx    context.Context
+			Allow  bool
+		}{
+			{"author", authorCtx, true},
+			{"work owner", ownerCtx, true},
+			{"other", viewerCtx, false},
+			// replier can not see hidden top-level comment anymore
+			{"replier to reply", replierCtx, false},
+		}
+		for _, tc := range cases {
+			for _, id := range []string{parentID, replyID} {
+				_, err := work.PostComment(tc.Ctx, &work.CommentRequest{ID: workID, ParentID: id, Content: "reply"})
+				if tc.Allow && err != nil {
+					t.Errorf("%s: reply to %s; %v", tc.Viewer, id, err)
+				}
+				if !tc.Allow && err == nil {
+					t.Errorf("%s: reply to %s; expected not found", tc.Viewer, id)
+				}
+			}
+		}
+	})
+
+	t.Run("deleted comment with hidden replies", func(t *testing.T) {
+		parentID := comment(authorCtx, "")
+		replyID := comment(replierCtx, parentID)
+		hide(replyID)
+
+		_, err := work.DeleteComment(authorCtx, &work.DeleteCommentRequest{ID: parentID})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// placeholder is listed only when viewer can see any of its replies
+		cases := []struct {
+			Viewer      string
+			Ctx         context.Context
+			Placeholder bool
+		}{
+			{"work owner", ownerCtx, true},
+			{"replier", replierCtx, true},
+			{"author", authorCtx, false},
+			{"other", viewerCtx, false},
+			{"anonymous", testdb.WithUser(ctx, ""), false},
+		}
+		for _, tc := range cases {
+			r, err := work.GetComments(tc.Ctx, &work.GetCommentsRequest{ID: workID, Limit: 100})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			found := false
+			for _, x := range r.List {
+				if x.ID == parentID {
+					found = true
+				}
+			}
+			if found != tc.Placeholder {
+				t.Errorf("%s: placeholder listed %v; want %v", tc.Viewer, found, tc.Placeholder)
+			}
+		}
+	})
+}
@@ -5,6 +5,8 @@ import (
 )
 
 var (
-	errInvalidCredentials = arpc.NewError("invalid credentials")
-	errWorkNotFound       = arpc.NewError("photo not found")
+	errInvalidCredentials      = arpc.NewError("invalid credentials")
+	errWorkNotFound            = arpc.NewError("photo not found")
+	errCommentNotFound         = arpc.NewError("comment not found")
+	errCommentEditWindowPassed = arpc.NewError("comment can no longer be edited")
 )
@@ -0,0 +1,110 @@
+package work
+
+import (
+	"context"
+
+	"github.com/acoshift/pgsql/pgctx"
+	"github.com/asaskevich/govalidator"
+
+	"github.com/acoshift/pikkanode/internal/file"
+	"github.com/acoshift/pikkanode/internal/paginate"
+	"github.com/acoshift/pikkanode/internal/session"
+	"github.com/acoshift/pikkanode/internal/validator"
+)
+
+type GetFavoritersRequest struct {
+	ID       string            `json:"id"`
+	Paginate paginate.Paginate `json:"paginate"`
+}
+
+func (req *GetFavoritersRequest) Valid() error {
+	v := validator.New()
+	v.Must(req.ID != "", "id required")
+	v.Must(govalidator.IsNumeric(req.ID), "invalid id")
+
+	return v.Error()
+}
+
+type FavoriterItem struct {
+	Username  string           `json:"username"`
+	Photo     file.DownloadURL `json:"photo"`
+	Following bool             `json:"following"`
+}
+
+type GetFavoritersResult struct {
+	List     []*FavoriterItem  `json:"list"`
+	Paginate paginate.Paginate `json:"paginate"`
+}
+
+// GetFavoriters lists users who favorited the work, newest first
+func GetFavoriters(ctx context.Context, req *GetFavoritersRequest) (*GetFavoritersResult, error) {
+	userID := session.GetUserID(ctx)
+
+	ok, err := canViewWork(ctx, req.ID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errWorkNotFound
+	}
+
+	var r GetFavoritersResult
+	{
+		err := req.Paginate.CountFrom(func() (cnt int64, err error) {
+			// language=SQL
+			err = pgctx.QueryRow(ctx, `
+				select count(*)
+				from favorites
+				where work_id = $1
+				  and not is_blocked(user_id, nullif($2, '')::uuid)
+			`, req.ID, userID).Scan(&cnt)
+			return
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	{
+		// language=SQL
+		rows, err := pgctx.Query(ctx, `
+			select
+				u.username, u.photo,
+				vf.user_id is not null
+			from favorites f
+				left join users u on f.user_id = u.id
+				left join follows vf on vf.following_id = u.id and ($3 != '' and vf.user_id = $3::uuid)
+			where f.work_id = $4
+			  and not is_blocked(f.user_id, nullif($3, '')::uuid)
+			order by f.created_at desc
+			offset $1 limit $2
+		`, req.Paginate.Offset(), req.Paginate.Limit(), userID, req.ID)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		r.List = make([]*FavoriterItem, 0)
+		r.Paginate = req.Paginate
+
+		for rows.Next() {
+			var x FavoriterItem
+			err := rows.Scan(
+				&x.Username, &x.Photo,
+				&x.Following,
+			)
+			if err != nil {
+				return nil, err
+			}
+			r.List = append(r.List, &x)
+		}
+
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+
+		rows.Close()
+	}
+
+	return &r, nil
+}
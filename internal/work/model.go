@@ -0,0 +1,21 @@
+package work
+
+import (
+	"context"
+
+	"github.com/acoshift/pgsql/pgctx"
+)
+
+// canViewWork returns true if user can see the work,
+// not found work is viewable, let the foreign key reports it
+func canViewWork(ctx context.Context, workID, userID string) (ok bool, err error) {
+	// language=SQL
+	err = pgctx.QueryRow(ctx, `
+		select not exists(
+			select 1
+			from works
+			where id = $1 and not can_view_work(user_id, visibility, status, nullif($2, '')::uuid)
+		)
+	`, workID, userID).Scan(&ok)
+	return
+}
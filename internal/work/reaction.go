@@ -0,0 +1,169 @@
+package work
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/acoshift/pgsql"
+	"github.com/acoshift/pgsql/pgctx"
+	"github.com/asaskevich/govalidator"
+
+	"github.com/acoshift/pikkanode/internal/config"
+	"github.com/acoshift/pikkanode/internal/session"
+	"github.com/acoshift/pikkanode/internal/validator"
+)
+
+var defaultReactions = []string{"like", "love", "wow", "laugh", "sad"}
+
+// reactions contains allowed reactions
+var reactions = config.StringSet("reactions", defaultReactions)
+
+// ReactionCounts is number of users per reaction
+type ReactionCounts map[string]int64
+
+// Scan scans json object from database
+func (c *ReactionCounts) Scan(src interface{}) error {
+	*c = make(ReactionCounts)
+	switch src := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(src, c)
+	case string:
+		return json.Unmarshal([]byte(src), c)
+	default:
+		return fmt.Errorf("work: can not scan %T into ReactionCounts", src)
+	}
+}
+
+type ReactRequest struct {
+	ID       string `json:"id"`
+	Reaction string `json:"reaction"` // empty to clear my reaction
+}
+
+func (req *ReactRequest) Valid() error {
+	v := validator.New()
+	v.Must(req.ID != "", "id required")
+	v.Must(govalidator.IsNumeric(req.ID), "invalid id")
+	v.Must(req.Reaction == "" || reactions[req.Reaction], "invalid reaction")
+
+	return v.Error()
+}
+
+// React sets or clears my reaction on a work
+func React(ctx context.Context, req *ReactRequest) (*struct{}, error) {
+	userID := session.GetUserID(ctx)
+	if userID == "" {
+		return nil, errInvalidCredentials
+	}
+
+	if req.Reaction == "" {
+		// language=SQL
+		_, err := pgctx.Exec(ctx, `
+			delete from reactions where user_id = $1 and work_id = $2
+		`, userID, req.ID)
+		if err != nil {
+			return nil, err
+		}
+		return new(struct{}), nil
+	}
+
+	ok, err := canViewWork(ctx, req.ID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errWorkNotFound
+	}
+
+	// language=SQL
+	_, err = pgctx.Exec(ctx, `
+		insert into reactions
+			(user_id, work_id, reaction)
+		values
+			($1, $2, $3)
+		on conflict (user_id, work_id) where work_id is not null
+		do update set reaction = excluded.reaction,
+		              created_at = now()
+	`, userID, req.ID, req.Reaction)
+	if pgsql.IsForeignKeyViolation(err, "reactions_work_id_fkey") {
+		return nil, errWorkNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return new(struct{}), nil
+}
+
+type ReactCommentRequest struct {
+	ID       string `json:"id"`       // comment id
+	Reaction string `json:"reaction"` // empty to clear my reaction
+}
+
+func (req *ReactCommentRequest) Valid() error {
+	v := validator.New()
+	v.Must(req.ID != "", "id required")
+	v.Must(govalidator.IsUUID(req.ID), "invalid id")
+	v.Must(req.Reaction == "" || reactions[req.Reaction], "invalid reaction")
+
+	return v.Error()
+}
+
+// ReactComment sets or clears my reaction on a comment
+func ReactComment(ctx context.Context, req *ReactCommentRequest) (*struct{}, error) {
+	userID := session.GetUserID(ctx)
+	if userID == "" {
+		return nil, errInvalidCredentials
+	}
+
+	if req.Reaction == "" {
+		// language=SQL
+		_, err := pgctx.Exec(ctx, `
+			delete from reactions where user_id = $1 and comment_id = $2
+		`, userID, req.ID)
+		if err != nil {
+			return nil, err
+		}
+		return new(struct{}), nil
+	}
+
+	var ok bool
+	// language=SQL
+	err := pgctx.QueryRow(ctx, `
+		select exists(
+			select 1
+			from comments c
+				join works w on c.work_id = w.id
+			where c.id = $1 and c.deleted_at is null
+			  and can_view_comment(c, w.user_id, $2)
+			  and can_view_work(w.user_id, w.visibility, w.status, $2)
+		)
+	`, req.ID, userID).Scan(&ok)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errCommentNotFound
+	}
+
+	// language=SQL
+	_, err = pgctx.Exec(ctx, `
+		insert into reactions
+			(user_id, comment_id, reaction)
+		values
+			($1, $2, $3)
+		on conflict (user_id, comment_id) where comment_id is not null
+		do update set reaction = excluded.reaction,
+		              created_at = now()
+	`, userID, req.ID, req.Reaction)
+	if pgsql.IsForeignKeyViolation(err, "reactions_comment_id_fkey") {
+		return nil, errCommentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return new(struct{}), nil
+}
@@ -0,0 +1,287 @@
+package work_test
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/acoshift/pgsql/pgctx"
+
+	"github.com/acoshift/pikkanode/internal/collection"
+	"github.com/acoshift/pikkanode/internal/discovery"
+	"github.com/acoshift/pikkanode/internal/me"
+	"github.com/acoshift/pikkanode/internal/testdb"
+	"github.com/acoshift/pikkanode/internal/user"
+	"github.com/acoshift/pikkanode/internal/work"
+)
+
+func TestMain(m *testing.M) {
+	testdb.Main(m)
+}
+
+// seeded works by name, draft is a public work not published yet
+var visibilityWorks = []string{"public", "unlisted", "followers", "private", "draft"}
+
+func TestVisibility(t *testing.T) {
+	ctx := testdb.Context(t)
+
+	ownerID, ownerUsername := testdb.CreateUser(ctx, t)
+	followerID, _ := testdb.CreateUser(ctx, t)
+	otherID, _ := testdb.CreateUser(ctx, t)
+	blockedID, blockedUsername := testdb.CreateUser(ctx, t)
+
+	ownerCtx := testdb.WithUser(ctx, ownerID)
+	for _, id := range []string{followerID, blockedID} {
+		_, err := user.Follow(testdb.WithUser(ctx, id), &user.FollowRequest{Username: ownerUsername, Follow: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := me.Block(ownerCtx, &me.BlockRequest{Username: blockedUsername, Block: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	workIDs := make(map[string]string)
+	names := make(map[string]string)
+	for _, name := range visibilityWorks {
+		visibility, status := name, "published"
+		if name == "draft" {
+			visibility, status = "public", "draft"
+		}
+
+		var id string
+		// language=SQL
+		err := pgctx.QueryRow(ctx, `
+			insert into works
+				(user_id, name, photo, visibility, status, publish_at)
+			values
+				($1, $2, '', $3, $4, case when $4 = 'published' then now() end)
+			returning id
+		`, ownerID, name, visibility, status).Scan(&id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		workIDs[name] = id
+		names[id] = name
+	}
+
+	// collections of each visibility contain all works
+	collectionIDs := make(map[string]string)
+	for _, visibility := range []string{"public", "unlisted", "followers", "private"} {
+		var id string
+		// language=SQL
+		err := pgctx.QueryRow(ctx, `
+			insert into collections
+				(user_id, name, visibility)
+			values
+				($1, $2, $2)
+			returning id
+		`, ownerID, visibility).Scan(&id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, name := range visibilityWorks {
+			testdb.Exec(ctx, t, `
+				insert into collection_works
+					(collection_id, work_id, position)
+				values
+					($1, $2, $3)
+			`, id, workIDs[name], i)
+		}
+		collectionIDs[visibility] = id
+	}
+
+	// only works from owner, other tests may create works
+	ownerWorks := func(xs []*discovery.WorkItem) []string {
+		r := make([]string, 0)
+		for _, x := range xs {
+			if name, ok := names[x.ID]; ok {
+				r = append(r, name)
+			}
+		}
+		sort.Strings(r)
+		return r
+	}
+
+	// without returns sorted xs without excluded names
+	without := func(xs []string, excluded ...string) []string {
+		r := make([]string, 0)
+	loop:
+		for _, x := range xs {
+			for _, e := range excluded {
+				if x == e {
+					continue loop
+				}
+			}
+			r = append(r, x)
+		}
+		sort.Strings(r)
+		return r
+	}
+
+	cases := []struct {
+		Viewer    string
+		UserID    string
+		Following bool
+		View      []string // works viewable by id
+	}{
+		{"anonymous", "", false, []string{"public", "unlisted"}},
+		{"owner", ownerID, false, []string{"public", "unlisted", "followers", "private", "draft"}},
+		{"follower", followerID, true, []string{"public", "unlisted", "followers"}},
+		{"non-follower", otherID, false, []string{"public", "unlisted"}},
+		{"blocked", blockedID, false, []string{}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Viewer, func(t *testing.T) {
+			ctx := testdb.WithUser(ctx, tc.UserID)
+
+			view := make(map[string]bool)
+			for _, name := range tc.View {
+				view[name] = true
+			}
+
+			// unlisted works and not published works are not listed
+			listed := without(tc.View, "unlisted", "draft")
+
+			for _, name := range visibilityWorks {
+				_, err := work.Get(ctx, &work.GetRequest{ID: workIDs[name]})
+				if view[name] && err != nil {
+					t.Errorf("work.Get %s; %v", name, err)
+				}
+				if !view[name] && err == nil {
+					t.Errorf("work.Get %s; expected not found", name)
+				}
+
+				_, err = work.GetComments(ctx, &work.GetCommentsRequest{ID: workIDs[name], Limit: 10})
+				if view[name] && err != nil {
+					t.Errorf("work.GetComments %s; %v", name, err)
+				}
+				if !view[name] && err == nil {
+					t.Errorf("work.GetComments %s; expected not found", name)
+				}
+
+				// anonymous can not comment
+				if tc.UserID == "" {
+					continue
+				}
+				_, err = work.PostComment(ctx, &work.CommentRequest{ID: workIDs[name], Content: "comment"})
+				if view[name] && err != nil {
+					t.Errorf("work.PostComment %s; %v", name, err)
+				}
+				if !view[name] && err == nil {
+					t.Errorf("work.PostComment %s; expected not found", name)
+				}
+			}
+
+			{
+				r, err := discovery.GetWorks(ctx, &discovery.GetWorksRequest{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := ownerWorks(r.List); !reflect.DeepEqual(got, listed) {
+					t.Errorf("discovery.GetWorks; got %v; want %v", got, listed)
+				}
+			}
+
+			if tc.UserID != "" {
+				req := discovery.GetFollowingFeedRequest{}
+				err := req.Valid()
+				if err != nil {
+					t.Fatal(err)
+				}
+				r, err := discovery.GetFollowingFeed(ctx, &req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				want := []string{}
+				if tc.Following {
+					want = listed
+				}
+				if got := ownerWorks(r.List); !reflect.DeepEqual(got, want) {
+					t.Errorf("discovery.GetFollowingFeed; got %v; want %v", got, want)
+				}
+			}
+
+			// favorited works keep visibility rules, ex. work changed to private after favorited
+			if tc.UserID != "" {
+				testdb.Exec(ctx, t, `
+					insert into favorites
+						(user_id, work_id)
+					select $1, id
+					from works
+					where user_id = $2
+					on conflict do nothing
+				`, tc.UserID, ownerID)
+
+				r, err := me.GetMyFavoriteWorks(ctx, &me.GetMyFavoriteWorksRequest{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				got := make([]string, 0)
+				for _, x := range r.List {
+					if name, ok := names[x.ID]; ok {
+						got = append(got, name)
+					}
+				}
+				sort.Strings(got)
+				if want := without(tc.View); !reflect.DeepEqual(got, want) {
+					t.Errorf("me.GetMyFavoriteWorks; got %v; want %v", got, want)
+				}
+			}
+
+			{
+				r, err := user.GetWorks(ctx, &user.GetWorksRequest{Username: ownerUsername})
+				if tc.Viewer == "blocked" {
+					if err == nil {
+						t.Errorf("user.GetWorks; expected not found")
+					}
+				} else {
+					if err != nil {
+						t.Fatal(err)
+					}
+					if got := ownerWorks(r.List); !reflect.DeepEqual(got, listed) {
+						t.Errorf("user.GetWorks; got %v; want %v", got, listed)
+					}
+				}
+			}
+
+			{
+				r, err := user.Profile(ctx, &user.ProfileRequest{Username: ownerUsername})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if tc.Viewer == "blocked" {
+					if r != nil {
+						t.Errorf("user.Profile; expected not found")
+					}
+				} else if r == nil {
+					t.Errorf("user.Profile; not found")
+				} else if r.WorkCount != int64(len(listed)) {
+					t.Errorf("user.Profile work count; got %d; want %d", r.WorkCount, len(listed))
+				}
+			}
+
+			// collection visibility follows work visibility,
+			// collection lists unlisted works but not unpublished works
+			for visibility, id := range collectionIDs {
+				r, err := collection.Get(ctx, &collection.GetRequest{ID: id})
+				if !view[visibility] {
+					if err == nil {
+						t.Errorf("collection.Get %s; expected not found", visibility)
+					}
+					continue
+				}
+				if err != nil {
+					t.Errorf("collection.Get %s; %v", visibility, err)
+					continue
+				}
+				want := without(tc.View, "draft")
+				if got := ownerWorks(r.List); !reflect.DeepEqual(got, want) {
+					t.Errorf("collection.Get %s; got %v; want %v", visibility, got, want)
+				}
+			}
+		})
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/lib/pq"
 
 	"github.com/acoshift/pikkanode/internal/file"
+	"github.com/acoshift/pikkanode/internal/mention"
 	"github.com/acoshift/pikkanode/internal/session"
 	"github.com/acoshift/pikkanode/internal/validator"
 )
@@ -28,26 +29,46 @@ func (req *GetRequest) Valid() error {
 	return v.Error()
 }
 
+// CommentItem is a comment,
+// deleted comment is a placeholder without content and user
 type CommentItem struct {
-	ID        string    `json:"id"`
-	Content   string    `json:"content"`
-	CreatedAt time.Time `json:"createdAt"`
-	User      struct {
+	ID         string     `json:"id"`
+	Content    string     `json:"content"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	EditedAt   *time.Time `json:"editedAt"`
+	IsHidden   bool       `json:"isHidden"`
+	IsDeleted  bool       `json:"isDeleted"`
+	ReplyCount int64      `json:"replyCount"`
+	User       struct {
 		Username string           `json:"username"`
 		Photo    file.DownloadURL `json:"photo"`
 	} `json:"user"`
+	Mentions   mention.Entities `json:"mentions"`
+	Reactions  ReactionCounts   `json:"reactions"`
+	MyReaction string           `json:"myReaction"` // empty when not reacted
 }
 
 type GetResult struct {
-	ID         string           `json:"id"`
-	Name       string           `json:"name"`
-	Detail     string           `json:"detail"`
-	Photo      file.DownloadURL `json:"photo"`
-	Tags       []string         `json:"tags"`
-	Username   string           `json:"username"`
-	Comments   []*CommentItem   `json:"comments"`
-	IsFavorite bool             `json:"isFavorite"`
-	CreatedAt  time.Time        `json:"createdAt"`
+	ID            string           `json:"id"`
+	Name          string           `json:"name"`
+	Detail        string           `json:"detail"`
+	Mentions      mention.Entities `json:"mentions"` // mentions in detail
+	Photo         file.DownloadURL `json:"photo"`
+	Tags          []string         `json:"tags"`
+	Visibility    string           `json:"visibility"`
+	Status        string           `json:"status"`
+	PublishAt     *time.Time       `json:"publishAt"`
+	Username      string           `json:"username"`
+	IsFavorite    bool             `json:"isFavorite"`
+	FavoriteCount int64            `json:"favoriteCount"`
+	CommentCount  int64            `json:"commentCount"`
+	Reactions     ReactionCounts   `json:"reactions"`
+	MyReaction    string           `json:"myReaction"` // empty when not reacted
+	CreatedAt     time.Time        `json:"createdAt"`
+
+	// first page of newest comments, use work/getComments for next pages
+	Comments      []*CommentItem `json:"comments"`
+	CommentCursor string         `json:"commentCursor"`
 }
 
 func Get(ctx context.Context, req *GetRequest) (*GetResult, error) {
@@ -58,17 +79,23 @@ func Get(ctx context.Context, req *GetRequest) (*GetResult, error) {
 		// language=SQL
 		err := pgctx.QueryRow(ctx, `
 			select
-				w.id, w.name, w.detail, w.photo, w.tags, w.created_at,
+				w.id, w.name, w.detail, work_mentions(w.id), w.photo, w.tags, w.visibility, w.status, w.publish_at, w.created_at,
 				u.username,
-				f.work_id is not null as is_favorite
+				w.favorite_count, w.comment_count,
+				f.work_id is not null as is_favorite,
+				work_reactions(w.id),
+				coalesce((select reaction from reactions where work_id = w.id and user_id = nullif($2, '')::uuid), '')
 			from works w
 				left join users u on w.user_id = u.id
 				left join favorites f on w.id = f.work_id and ($2 != '' and f.user_id = $2::uuid)
 			where w.id = $1
+			  and can_view_work(w.user_id, w.visibility, w.status, nullif($2, '')::uuid)
 		`, req.ID, userID).Scan(
-			&r.ID, &r.Name, &r.Detail, &r.Photo, pq.Array(&r.Tags), &r.CreatedAt,
+			&r.ID, &r.Name, &r.Detail, &r.Mentions, &r.Photo, pq.Array(&r.Tags), &r.Visibility, &r.Status, &r.PublishAt, &r.CreatedAt,
 			&r.Username,
+			&r.FavoriteCount, &r.CommentCount,
 			&r.IsFavorite,
+			&r.Reactions, &r.MyReaction,
 		)
 		if err == sql.ErrNoRows {
 			return nil, errWorkNotFound
@@ -79,38 +106,16 @@ func Get(ctx context.Context, req *GetRequest) (*GetResult, error) {
 	}
 
 	{
-		// language=SQL
-		rows, err := pgctx.Query(ctx, `
-			select
-				c.id, c.content, c.created_at,
-				u.username, u.photo
-			from comments c
-				left join users u on c.user_id = u.id
-			where c.work_id = $1
-		`, req.ID)
+		var err error
+		r.Comments, r.CommentCursor, err = getComments(ctx, &commentsQuery{
+			WorkID: req.ID,
+			UserID: userID,
+			Sort:   SortNewest,
+			Limit:  defaultCommentLimit,
+		})
 		if err != nil {
 			return nil, err
 		}
-		defer rows.Close()
-
-		r.Comments = make([]*CommentItem, 0)
-		for rows.Next() {
-			var x CommentItem
-			err := rows.Scan(
-				&x.ID, &x.Content, &x.CreatedAt,
-				&x.User.Username, &x.User.Photo,
-			)
-			if err != nil {
-				return nil, err
-			}
-			r.Comments = append(r.Comments, &x)
-		}
-
-		if err := rows.Err(); err != nil {
-			return nil, err
-		}
-
-		rows.Close()
 	}
 
 	return &r, nil
@@ -136,8 +141,16 @@ func Favorite(ctx context.Context, req *FavoriteRequest) (*struct{}, error) {
 	}
 
 	if req.Favorite {
+		ok, err := canViewWork(ctx, req.ID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, errWorkNotFound
+		}
+
 		// language=SQL
-		_, err := pgctx.Exec(ctx, `
+		_, err = pgctx.Exec(ctx, `
 		insert into favorites
 			(user_id, work_id)
 		values
@@ -164,39 +177,91 @@ func Favorite(ctx context.Context, req *FavoriteRequest) (*struct{}, error) {
 }
 
 type CommentRequest struct {
-	ID      string `json:"id"`
-	Content string `json:"content"`
+	ID       string `json:"id"`
+	ParentID string `json:"parentId"` // optional, comment id to reply to
+	Content  string `json:"content"`
 }
 
 func (req *CommentRequest) Valid() error {
 	v := validator.New()
 	v.Must(req.ID != "", "id required")
 	v.Must(govalidator.IsNumeric(req.ID), "invalid id")
+	v.Must(req.ParentID == "" || govalidator.IsUUID(req.ParentID), "invalid parent id")
 	v.Must(req.Content != "", "content required")
 	v.Must(utf8.RuneCountInString(req.Content) <= 255, "content length maximum 255 characters")
 
 	return v.Error()
 }
 
-func PostComment(ctx context.Context, req *CommentRequest) (*struct{}, error) {
+// PostComment posts a comment or a reply, returns the created comment
+func PostComment(ctx context.Context, req *CommentRequest) (*CommentItem, error) {
 	userID := session.GetUserID(ctx)
 	if userID == "" {
 		return nil, errInvalidCredentials
 	}
 
-	// language=SQL
-	_, err := pgctx.Exec(ctx, `
-		insert into comments
-			(user_id, work_id, content)
-		values
-			($1, $2, $3)
-	`, userID, req.ID, req.Content)
-	if pgsql.IsForeignKeyViolation(err, "comments_work_id_fkey") {
+	ok, err := canViewWork(ctx, req.ID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
 		return nil, errWorkNotFound
 	}
+
+	// reply to a reply goes to the top-level comment,
+	// keep threads one level deep.
+	// hidden comment can be replied only by its author and work owner
+	var parentID *string
+	if req.ParentID != "" {
+		// language=SQL
+		err := pgctx.QueryRow(ctx, `
+			select coalesce(c.parent_id, c.id)
+			from comments c
+				join works w on c.work_id = w.id
+				left join comments p on c.parent_id = p.id
+			where c.id = $1 and c.work_id = $2 and c.deleted_at is null
+			  and can_view_comment(c, w.user_id, $3)
+			  and (p.id is null or can_view_comment(p, w.user_id, $3))
+		`, req.ParentID, req.ID, userID).Scan(&parentID)
+		if err == sql.ErrNoRows {
+			return nil, errCommentNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var r CommentItem
+	err = pgctx.RunInTx(ctx, func(ctx context.Context) error {
+		// language=SQL
+		err := pgctx.QueryRow(ctx, `
+			with c as (
+				insert into comments
+					(user_id, work_id, parent_id, content)
+				values
+					($1, $2, $3, $4)
+				returning id, user_id, content, created_at
+			)
+			select c.id, c.content, c.created_at, u.username, u.photo
+			from c
+				left join users u on c.user_id = u.id
+		`, userID, req.ID, parentID, req.Content).Scan(
+			&r.ID, &r.Content, &r.CreatedAt, &r.User.Username, &r.User.Photo,
+		)
+		if pgsql.IsForeignKeyViolation(err, "comments_work_id_fkey") {
+			return errWorkNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		r.Mentions, err = mention.SetComment(ctx, userID, r.ID, r.Content)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
+	r.Reactions = make(ReactionCounts)
 
-	return new(struct{}), nil
+	return &r, nil
 }
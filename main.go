@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
 	"log"
+	"os"
 	"time"
 
+	"github.com/acoshift/pgsql/pgctx"
+
 	"github.com/moonrhythm/parapet"
 	"github.com/moonrhythm/parapet/pkg/cors"
 	"github.com/moonrhythm/parapet/pkg/healthz"
@@ -12,10 +16,18 @@ import (
 	"github.com/moonrhythm/parapet/pkg/redirect"
 
 	"github.com/acoshift/pikkanode/internal/config"
+	"github.com/acoshift/pikkanode/internal/counter"
 	"github.com/acoshift/pikkanode/internal/handler"
+	"github.com/acoshift/pikkanode/internal/publish"
+	"github.com/acoshift/pikkanode/internal/purge"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		runCommand(os.Args[1])
+		return
+	}
+
 	svc := parapet.NewBackend()
 	svc.Use(health())
 	if !config.Dev() {
@@ -24,7 +36,7 @@ func main() {
 	svc.Use(cors.CORS{
 		MaxAge:           time.Hour,
 		AllowCredentials: true,
-		AllowHeaders:     []string{"Content-Type"},
+		AllowHeaders:     []string{"Content-Type", "Authorization"},
 		AllowMethods:     []string{"POST"},
 		AllowOrigins: []string{
 			"http://localhost:8080",
@@ -41,6 +53,9 @@ func main() {
 	svc.Handler = handler.New()
 	svc.Addr = ":8080"
 
+	go purge.Run()
+	go publish.Run()
+
 	err := svc.ListenAndServe()
 	if err != nil {
 		log.Fatal(err)
@@ -54,3 +69,21 @@ func health() parapet.Middleware {
 	h.Use(l)
 	return h
 }
+
+// runCommand runs maintenance command then exits
+//
+//	repair-counts: recompute works' favorite and comment counts
+func runCommand(name string) {
+	ctx := pgctx.NewContext(context.Background(), config.DB())
+
+	switch name {
+	case "repair-counts":
+		n, err := counter.Repair(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("repaired %d works", n)
+	default:
+		log.Fatalf("unknown command %s", name)
+	}
+}
@@ -0,0 +1,12 @@
+-- sign in with OpenID Connect
+
+create table user_identities (
+    provider   varchar,
+    subject    varchar,
+    user_id    uuid      not null,
+    email      varchar   not null default '',
+    created_at timestamp not null default now(),
+    primary key (provider, subject),
+    foreign key (user_id) references users (id) on delete cascade
+);
+create index on user_identities (user_id);
@@ -0,0 +1,15 @@
+-- personal access tokens
+
+create table access_tokens (
+    id           uuid               default gen_random_uuid(),
+    user_id      uuid      not null,
+    name         varchar   not null,
+    token        varchar   not null,
+    scopes       varchar[] not null default '{}',
+    last_used_at timestamp,
+    created_at   timestamp not null default now(),
+    primary key (id),
+    foreign key (user_id) references users (id) on delete cascade
+);
+create unique index access_tokens_token_idx on access_tokens (token);
+create index on access_tokens (user_id, created_at desc);
@@ -0,0 +1,14 @@
+-- account deletion and data export
+
+alter table users add column delete_at timestamp;
+create index on users (delete_at) where delete_at is not null;
+
+create table data_exports (
+    id         uuid               default gen_random_uuid(),
+    user_id    uuid      not null,
+    file       varchar   not null,
+    created_at timestamp not null default now(),
+    primary key (id),
+    foreign key (user_id) references users (id) on delete cascade
+);
+create index on data_exports (user_id, created_at desc);
@@ -0,0 +1,18 @@
+-- invite-only registration
+
+alter table users add column is_admin bool not null default false;
+alter table users add column invite_code varchar;
+create index on users (invite_code);
+
+create table invites (
+    code       varchar,
+    user_id    uuid,
+    max_uses   int       not null default 1,
+    uses       int       not null default 0,
+    expires_at timestamp not null,
+    created_at timestamp not null default now(),
+    primary key (code),
+    foreign key (user_id) references users (id) on delete set null
+);
+create index on invites (user_id, created_at desc);
+alter table users add foreign key (invite_code) references invites (code) on delete set null;
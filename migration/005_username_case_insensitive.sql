@@ -0,0 +1,19 @@
+-- make username unique case-insensitive
+--
+-- clashed usernames must be resolved before running this migration,
+-- list them using:
+--
+--   select lower(username), array_agg(username order by created_at)
+--   from users
+--   group by lower(username)
+--   having count(*) > 1;
+
+do $$
+begin
+    if exists(select 1 from users group by lower(username) having count(*) > 1) then
+        raise exception 'username clashes found, resolve them before migrate';
+    end if;
+end $$;
+
+drop index users_username_idx;
+create unique index users_username_idx on users (lower(username));
@@ -0,0 +1,12 @@
+-- username change with history redirect
+
+alter table users add column username_changed_at timestamp;
+
+create table username_history (
+    username   varchar   not null,
+    user_id    uuid      not null,
+    created_at timestamp not null default now(),
+    foreign key (user_id) references users (id) on delete cascade
+);
+create unique index username_history_username_idx on username_history (lower(username));
+create index on username_history (user_id, created_at desc);
@@ -0,0 +1,8 @@
+-- editable profile fields and banner
+
+alter table users add column display_name varchar not null default '';
+alter table users add column bio varchar not null default '';
+alter table users add column links varchar[] not null default '{}';
+alter table users add column location varchar not null default '';
+alter table users add column pronouns varchar not null default '';
+alter table users add column banner varchar not null default '';
@@ -0,0 +1,39 @@
+-- block and mute users
+
+create table blocks (
+    user_id     uuid,
+    blocking_id uuid,
+    created_at  timestamp not null default now(),
+    primary key (user_id, blocking_id),
+    foreign key (user_id) references users (id) on delete cascade,
+    foreign key (blocking_id) references users (id) on delete cascade
+);
+create index on blocks (user_id, created_at desc);
+create index on blocks (blocking_id);
+
+create table mutes (
+    user_id    uuid,
+    muting_id  uuid,
+    created_at timestamp not null default now(),
+    primary key (user_id, muting_id),
+    foreign key (user_id) references users (id) on delete cascade,
+    foreign key (muting_id) references users (id) on delete cascade
+);
+create index on mutes (user_id, created_at desc);
+
+create function is_blocked(a uuid, b uuid) returns bool as $$
+    select exists(
+        select 1
+        from blocks
+        where (user_id = a and blocking_id = b)
+           or (user_id = b and blocking_id = a)
+    )
+$$ language sql stable;
+
+create function is_muted(a uuid, b uuid) returns bool as $$
+    select exists(
+        select 1
+        from mutes
+        where user_id = a and muting_id = b
+    )
+$$ language sql stable;
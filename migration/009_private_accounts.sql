@@ -0,0 +1,21 @@
+-- private accounts and follow requests
+
+alter table users add column is_private bool not null default false;
+
+create table follow_requests (
+    user_id      uuid,
+    following_id uuid,
+    created_at   timestamp not null default now(),
+    primary key (user_id, following_id),
+    foreign key (user_id) references users (id) on delete cascade,
+    foreign key (following_id) references users (id) on delete cascade
+);
+create index on follow_requests (following_id, created_at desc);
+
+create function can_view(owner uuid, viewer uuid) returns bool as $$
+    select not is_blocked(owner, viewer) and (
+        coalesce(owner = viewer, false)
+        or not (select is_private from users where id = owner)
+        or exists(select 1 from follows where user_id = viewer and following_id = owner)
+    )
+$$ language sql stable;
@@ -0,0 +1,12 @@
+-- per-work visibility
+
+alter table works add column visibility varchar not null default 'public';
+
+create function can_view_work(owner uuid, visibility varchar, viewer uuid) returns bool as $$
+    select can_view(owner, viewer) and case visibility
+        when 'private' then coalesce(owner = viewer, false)
+        when 'followers' then coalesce(owner = viewer, false)
+            or exists(select 1 from follows where user_id = viewer and following_id = owner)
+        else true
+    end
+$$ language sql stable;
@@ -0,0 +1,24 @@
+-- drafts and scheduled publishing
+
+alter table works add column status varchar not null default 'published';
+alter table works add column publish_at timestamp default now();
+
+-- existing works were published when created
+update works set publish_at = created_at;
+
+create index on works (publish_at desc) where status = 'published';
+create index on works (user_id, publish_at desc) where status = 'published';
+create index on works (publish_at) where status = 'scheduled';
+
+drop function can_view_work(uuid, varchar, uuid);
+
+create function can_view_work(owner uuid, visibility varchar, status varchar, viewer uuid) returns bool as $$
+    select can_view(owner, viewer)
+        and (status = 'published' or coalesce(owner = viewer, false))
+        and case visibility
+            when 'private' then coalesce(owner = viewer, false)
+            when 'followers' then coalesce(owner = viewer, false)
+                or exists(select 1 from follows where user_id = viewer and following_id = owner)
+            else true
+        end
+$$ language sql stable;
@@ -0,0 +1,49 @@
+-- collections of works
+
+create table collections (
+    id            bigserial,
+    user_id       uuid      not null,
+    name          varchar   not null,
+    description   varchar   not null default '',
+    cover_work_id bigint,
+    visibility    varchar   not null default 'public',
+    position      int       not null default 0,
+    created_at    timestamp not null default now(),
+    primary key (id),
+    foreign key (user_id) references users (id) on delete cascade,
+    foreign key (cover_work_id) references works (id) on delete set null
+);
+create index on collections (user_id, position);
+
+create table collection_works (
+    collection_id bigint,
+    work_id       bigint,
+    position      int       not null default 0,
+    created_at    timestamp not null default now(),
+    primary key (collection_id, work_id),
+    foreign key (collection_id) references collections (id) on delete cascade,
+    foreign key (work_id) references works (id) on delete cascade
+);
+create index on collection_works (collection_id, position);
+create index on collection_works (work_id);
+
+create function collection_cover(c collections, viewer uuid) returns varchar as $$
+    select coalesce(
+        (
+            select w.photo
+            from works w
+            where w.id = c.cover_work_id
+              and can_view_work(w.user_id, w.visibility, w.status, viewer)
+        ),
+        (
+            select w.photo
+            from collection_works cw
+                join works w on cw.work_id = w.id
+            where cw.collection_id = c.id
+              and can_view_work(w.user_id, w.visibility, w.status, viewer)
+            order by cw.position
+            limit 1
+        ),
+        ''
+    )
+$$ language sql stable;
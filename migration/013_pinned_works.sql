@@ -0,0 +1,4 @@
+-- pinned works
+
+alter table works add column pin_order int;
+create index on works (user_id, pin_order) where pin_order is not null;
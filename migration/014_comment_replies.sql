@@ -0,0 +1,5 @@
+-- threaded comment replies
+
+alter table comments add column parent_id uuid;
+alter table comments add foreign key (parent_id) references comments (id) on delete cascade;
+create index on comments (parent_id, created_at);
@@ -0,0 +1,11 @@
+-- comment edit, delete and moderation
+
+alter table comments add column edited_at timestamp;
+alter table comments add column hidden_at timestamp;
+alter table comments add column deleted_at timestamp;
+
+create function can_view_comment(c comments, work_owner uuid, viewer uuid) returns bool as $$
+    select not is_blocked(c.user_id, viewer)
+        and not is_muted(viewer, c.user_id)
+        and (c.hidden_at is null or coalesce(viewer in (c.user_id, work_owner), false))
+$$ language sql stable;
@@ -0,0 +1,41 @@
+-- favorite and comment counts on works
+
+alter table works add column favorite_count int not null default 0;
+alter table works add column comment_count int not null default 0;
+
+create function favorites_count() returns trigger as $$
+begin
+    if tg_op = 'INSERT' then
+        update works set favorite_count = favorite_count + 1 where id = new.work_id;
+    elsif tg_op = 'DELETE' then
+        update works set favorite_count = favorite_count - 1 where id = old.work_id;
+    end if;
+    return null;
+end
+$$ language plpgsql;
+
+create trigger favorites_count
+    after insert or delete on favorites
+    for each row execute procedure favorites_count();
+
+create function comments_count() returns trigger as $$
+begin
+    if tg_op = 'INSERT' and new.deleted_at is null then
+        update works set comment_count = comment_count + 1 where id = new.work_id;
+    elsif tg_op = 'DELETE' and old.deleted_at is null then
+        update works set comment_count = comment_count - 1 where id = old.work_id;
+    elsif tg_op = 'UPDATE' and old.deleted_at is null and new.deleted_at is not null then
+        update works set comment_count = comment_count - 1 where id = new.work_id;
+    end if;
+    return null;
+end
+$$ language plpgsql;
+
+create trigger comments_count
+    after insert or delete or update of deleted_at on comments
+    for each row execute procedure comments_count();
+
+-- existing counts, same as "pikkanode repair-counts"
+update works w
+set favorite_count = (select count(*) from favorites where work_id = w.id),
+    comment_count = (select count(*) from comments where work_id = w.id and deleted_at is null);
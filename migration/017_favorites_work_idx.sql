@@ -0,0 +1,3 @@
+-- work favoriters list
+
+create index on favorites (work_id, created_at desc);
@@ -0,0 +1,37 @@
+-- emoji reactions on works and comments
+
+create table reactions (
+    user_id    uuid      not null,
+    work_id    bigint,
+    comment_id uuid,
+    reaction   varchar   not null,
+    created_at timestamp not null default now(),
+    foreign key (user_id) references users (id) on delete cascade,
+    foreign key (work_id) references works (id) on delete cascade,
+    foreign key (comment_id) references comments (id) on delete cascade,
+    check ((work_id is null) != (comment_id is null))
+);
+create unique index reactions_work_idx on reactions (user_id, work_id) where work_id is not null;
+create unique index reactions_comment_idx on reactions (user_id, comment_id) where comment_id is not null;
+create index on reactions (work_id, reaction) where work_id is not null;
+create index on reactions (comment_id, reaction) where comment_id is not null;
+
+create function work_reactions(id bigint) returns json as $$
+    select coalesce(json_object_agg(reaction, cnt), '{}')
+    from (
+        select reaction, count(*) as cnt
+        from reactions
+        where work_id = id
+        group by reaction
+    ) t
+$$ language sql stable;
+
+create function comment_reactions(id uuid) returns json as $$
+    select coalesce(json_object_agg(reaction, cnt), '{}')
+    from (
+        select reaction, count(*) as cnt
+        from reactions
+        where comment_id = id
+        group by reaction
+    ) t
+$$ language sql stable;
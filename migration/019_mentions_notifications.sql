@@ -0,0 +1,57 @@
+-- mentions and notifications
+
+create table mentions (
+    user_id    uuid      not null,
+    work_id    bigint,
+    comment_id uuid,
+    position   int       not null,
+    length     int       not null,
+    created_at timestamp not null default now(),
+    foreign key (user_id) references users (id) on delete cascade,
+    foreign key (work_id) references works (id) on delete cascade,
+    foreign key (comment_id) references comments (id) on delete cascade,
+    check ((work_id is null) != (comment_id is null))
+);
+create index on mentions (work_id) where work_id is not null;
+create index on mentions (comment_id) where comment_id is not null;
+create index on mentions (user_id);
+
+create table notifications (
+    id         uuid               default gen_random_uuid(),
+    user_id    uuid      not null,
+    actor_id   uuid      not null,
+    type       varchar   not null,
+    work_id    bigint,
+    comment_id uuid,
+    read_at    timestamp,
+    created_at timestamp not null default now(),
+    primary key (id),
+    foreign key (user_id) references users (id) on delete cascade,
+    foreign key (actor_id) references users (id) on delete cascade,
+    foreign key (work_id) references works (id) on delete cascade,
+    foreign key (comment_id) references comments (id) on delete cascade
+);
+create index on notifications (user_id, created_at desc);
+create index on notifications (actor_id);
+
+create function work_mentions(target bigint) returns json as $$
+    select coalesce(json_agg(json_build_object(
+        'offset', m.position,
+        'length', m.length,
+        'username', u.username
+    ) order by m.position), '[]')
+    from mentions m
+        join users u on m.user_id = u.id
+    where m.work_id = target
+$$ language sql stable;
+
+create function comment_mentions(target uuid) returns json as $$
+    select coalesce(json_agg(json_build_object(
+        'offset', m.position,
+        'length', m.length,
+        'username', u.username
+    ) order by m.position), '[]')
+    from mentions m
+        join users u on m.user_id = u.id
+    where m.comment_id = target
+$$ language sql stable;
@@ -0,0 +1,4 @@
+-- following feed keyset over (publish_at, id)
+
+drop index works_user_id_publish_at_idx;
+create index on works (user_id, publish_at desc, id desc) where status = 'published';
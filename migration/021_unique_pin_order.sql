@@ -0,0 +1,14 @@
+-- pinned works order is unique per user
+
+-- renumber pin order duplicated by concurrent pins
+update works w
+set pin_order = t.pin_order
+from (
+    select id, row_number() over (partition by user_id order by pin_order, id) - 1 as pin_order
+    from works
+    where pin_order is not null
+) t
+where w.id = t.id and w.pin_order != t.pin_order;
+
+drop index works_user_id_pin_order_idx;
+create unique index works_pin_order_idx on works (user_id, pin_order) where pin_order is not null;
@@ -0,0 +1,15 @@
+-- data exports expire, uploaded originals are kept for export, stored files are removed by purge
+
+alter table works add column original varchar not null default '';
+
+alter table data_exports add column expires_at timestamp;
+update data_exports set expires_at = created_at + interval '7 days';
+alter table data_exports alter column expires_at set not null;
+create index on data_exports (expires_at);
+
+create table file_removals (
+    file       varchar,
+    created_at timestamp not null default now(),
+    primary key (file)
+);
+create index on file_removals (created_at);
@@ -1,28 +1,85 @@
 create extension pgcrypto;
 
 create table users (
-    id         uuid               default gen_random_uuid(),
+    id                  uuid               default gen_random_uuid(),
+    username            varchar   not null,
+    password            varchar   not null,
+    display_name        varchar   not null default '',
+    bio                 varchar   not null default '',
+    links               varchar[] not null default '{}',
+    location            varchar   not null default '',
+    pronouns            varchar   not null default '',
+    photo               varchar   not null default '',
+    banner              varchar   not null default '',
+    is_admin            bool      not null default false,
+    is_private          bool      not null default false,
+    invite_code         varchar,
+    delete_at           timestamp,
+    username_changed_at timestamp,
+    created_at          timestamp not null default now(),
+    primary key (id)
+);
+create unique index users_username_idx on users (lower(username));
+create index on users (delete_at) where delete_at is not null;
+create index on users (invite_code);
+
+create table invites (
+    code       varchar,
+    user_id    uuid,
+    max_uses   int       not null default 1,
+    uses       int       not null default 0,
+    expires_at timestamp not null,
+    created_at timestamp not null default now(),
+    primary key (code),
+    foreign key (user_id) references users (id) on delete set null
+);
+create index on invites (user_id, created_at desc);
+alter table users add foreign key (invite_code) references invites (code) on delete set null;
+
+create table username_history (
     username   varchar   not null,
-    password   varchar   not null,
-    photo      varchar   not null default '',
+    user_id    uuid      not null,
     created_at timestamp not null default now(),
-    primary key (id)
+    foreign key (user_id) references users (id) on delete cascade
 );
-create unique index users_username_idx on users (username);
+create unique index username_history_username_idx on username_history (lower(username));
+create index on username_history (user_id, created_at desc);
 
-create table works (
-    id         bigserial,
+create table user_identities (
+    provider   varchar,
+    subject    varchar,
     user_id    uuid      not null,
-    name       varchar   not null,
-    detail     varchar   not null default '',
-    photo      varchar   not null,
-    tags       varchar[] not null default '{}',
+    email      varchar   not null default '',
     created_at timestamp not null default now(),
+    primary key (provider, subject),
+    foreign key (user_id) references users (id) on delete cascade
+);
+create index on user_identities (user_id);
+
+create table works (
+    id             bigserial,
+    user_id        uuid      not null,
+    name           varchar   not null,
+    detail         varchar   not null default '',
+    photo          varchar   not null,
+    original       varchar   not null default '', -- uploaded file, empty for works uploaded before kept
+    tags           varchar[] not null default '{}',
+    visibility     varchar   not null default 'public',
+    status         varchar   not null default 'published',
+    publish_at     timestamp          default now(),
+    pin_order      int,
+    favorite_count int       not null default 0,
+    comment_count  int       not null default 0,
+    created_at     timestamp not null default now(),
     primary key (id),
     foreign key (user_id) references users on delete cascade
 );
 create index on works (created_at desc);
 create index on works (user_id, created_at desc);
+create index on works (publish_at desc) where status = 'published';
+create index on works (user_id, publish_at desc, id desc) where status = 'published';
+create index on works (publish_at) where status = 'scheduled';
+create unique index works_pin_order_idx on works (user_id, pin_order) where pin_order is not null;
 
 create table favorites (
     user_id    uuid,
@@ -32,18 +89,105 @@ create table favorites (
     foreign key (user_id) references users (id) on delete cascade,
     foreign key (work_id) references works (id) on delete cascade
 );
+create index on favorites (work_id, created_at desc);
+
+create table collections (
+    id            bigserial,
+    user_id       uuid      not null,
+    name          varchar   not null,
+    description   varchar   not null default '',
+    cover_work_id bigint,
+    visibility    varchar   not null default 'public',
+    position      int       not null default 0,
+    created_at    timestamp not null default now(),
+    primary key (id),
+    foreign key (user_id) references users (id) on delete cascade,
+    foreign key (cover_work_id) references works (id) on delete set null
+);
+create index on collections (user_id, position);
+
+create table collection_works (
+    collection_id bigint,
+    work_id       bigint,
+    position      int       not null default 0,
+    created_at    timestamp not null default now(),
+    primary key (collection_id, work_id),
+    foreign key (collection_id) references collections (id) on delete cascade,
+    foreign key (work_id) references works (id) on delete cascade
+);
+create index on collection_works (collection_id, position);
+create index on collection_works (work_id);
 
 create table comments (
     id         uuid               default gen_random_uuid(),
     work_id bigint    not null,
     user_id    uuid      not null,
+    parent_id  uuid,
     content    varchar   not null,
+    edited_at  timestamp,
+    hidden_at  timestamp,
+    deleted_at timestamp,
     created_at timestamp not null default now(),
     primary key (id),
     foreign key (work_id) references works (id) on delete cascade,
-    foreign key (user_id) references users (id) on delete cascade
+    foreign key (user_id) references users (id) on delete cascade,
+    foreign key (parent_id) references comments (id) on delete cascade
 );
 create index on comments (work_id, created_at desc);
+create index on comments (parent_id, created_at);
+
+-- reactions on a work or a comment, one reaction per user per target
+create table reactions (
+    user_id    uuid      not null,
+    work_id    bigint,
+    comment_id uuid,
+    reaction   varchar   not null,
+    created_at timestamp not null default now(),
+    foreign key (user_id) references users (id) on delete cascade,
+    foreign key (work_id) references works (id) on delete cascade,
+    foreign key (comment_id) references comments (id) on delete cascade,
+    check ((work_id is null) != (comment_id is null))
+);
+create unique index reactions_work_idx on reactions (user_id, work_id) where work_id is not null;
+create unique index reactions_comment_idx on reactions (user_id, comment_id) where comment_id is not null;
+create index on reactions (work_id, reaction) where work_id is not null;
+create index on reactions (comment_id, reaction) where comment_id is not null;
+
+-- mentions in work's detail or comment's content,
+-- position and length are in characters
+create table mentions (
+    user_id    uuid      not null,
+    work_id    bigint,
+    comment_id uuid,
+    position   int       not null,
+    length     int       not null,
+    created_at timestamp not null default now(),
+    foreign key (user_id) references users (id) on delete cascade,
+    foreign key (work_id) references works (id) on delete cascade,
+    foreign key (comment_id) references comments (id) on delete cascade,
+    check ((work_id is null) != (comment_id is null))
+);
+create index on mentions (work_id) where work_id is not null;
+create index on mentions (comment_id) where comment_id is not null;
+create index on mentions (user_id);
+
+create table notifications (
+    id         uuid               default gen_random_uuid(),
+    user_id    uuid      not null,
+    actor_id   uuid      not null,
+    type       varchar   not null,
+    work_id    bigint,
+    comment_id uuid,
+    read_at    timestamp,
+    created_at timestamp not null default now(),
+    primary key (id),
+    foreign key (user_id) references users (id) on delete cascade,
+    foreign key (actor_id) references users (id) on delete cascade,
+    foreign key (work_id) references works (id) on delete cascade,
+    foreign key (comment_id) references comments (id) on delete cascade
+);
+create index on notifications (user_id, created_at desc);
+create index on notifications (actor_id);
 
 create table follows (
     user_id      uuid,
@@ -55,3 +199,225 @@ create table follows (
 );
 create index on follows (user_id, created_at desc);
 create index on follows (following_id, created_at desc);
+
+create table follow_requests (
+    user_id      uuid,
+    following_id uuid,
+    created_at   timestamp not null default now(),
+    primary key (user_id, following_id),
+    foreign key (user_id) references users (id) on delete cascade,
+    foreign key (following_id) references users (id) on delete cascade
+);
+create index on follow_requests (following_id, created_at desc);
+
+create table access_tokens (
+    id           uuid               default gen_random_uuid(),
+    user_id      uuid      not null,
+    name         varchar   not null,
+    token        varchar   not null,
+    scopes       varchar[] not null default '{}',
+    last_used_at timestamp,
+    created_at   timestamp not null default now(),
+    primary key (id),
+    foreign key (user_id) references users (id) on delete cascade
+);
+create unique index access_tokens_token_idx on access_tokens (token);
+create index on access_tokens (user_id, created_at desc);
+
+create table data_exports (
+    id         uuid               default gen_random_uuid(),
+    user_id    uuid      not null,
+    file       varchar   not null,
+    expires_at timestamp not null,
+    created_at timestamp not null default now(),
+    primary key (id),
+    foreign key (user_id) references users (id) on delete cascade
+);
+create index on data_exports (user_id, created_at desc);
+create index on data_exports (expires_at);
+
+-- stored files waiting to be removed by purge, removing is retried until success
+create table file_removals (
+    file       varchar,
+    created_at timestamp not null default now(),
+    primary key (file)
+);
+create index on file_removals (created_at);
+
+create table blocks (
+    user_id     uuid,
+    blocking_id uuid,
+    created_at  timestamp not null default now(),
+    primary key (user_id, blocking_id),
+    foreign key (user_id) references users (id) on delete cascade,
+    foreign key (blocking_id) references users (id) on delete cascade
+);
+create index on blocks (user_id, created_at desc);
+create index on blocks (blocking_id);
+
+create table mutes (
+    user_id    uuid,
+    muting_id  uuid,
+    created_at timestamp not null default now(),
+    primary key (user_id, muting_id),
+    foreign key (user_id) references users (id) on delete cascade,
+    foreign key (muting_id) references users (id) on delete cascade
+);
+create index on mutes (user_id, created_at desc);
+
+-- is_blocked returns true if user a blocked user b, or user b blocked user a
+create function is_blocked(a uuid, b uuid) returns bool as $$
+    select exists(
+        select 1
+        from blocks
+        where (user_id = a and blocking_id = b)
+           or (user_id = b and blocking_id = a)
+    )
+$$ language sql stable;
+
+-- is_muted returns true if user a muted user b
+create function is_muted(a uuid, b uuid) returns bool as $$
+    select exists(
+        select 1
+        from mutes
+        where user_id = a and muting_id = b
+    )
+$$ language sql stable;
+
+-- can_view returns true if viewer can see owner's works,
+-- viewer can be null for anonymous user
+create function can_view(owner uuid, viewer uuid) returns bool as $$
+    select not is_blocked(owner, viewer) and (
+        coalesce(owner = viewer, false)
+        or not (select is_private from users where id = owner)
+        or exists(select 1 from follows where user_id = viewer and following_id = owner)
+    )
+$$ language sql stable;
+
+-- can_view_work returns true if viewer can see the work,
+-- unlisted works are viewable, list queries must exclude them,
+-- not published works are viewable only by owner
+create function can_view_work(owner uuid, visibility varchar, status varchar, viewer uuid) returns bool as $$
+    select can_view(owner, viewer)
+        and (status = 'published' or coalesce(owner = viewer, false))
+        and case visibility
+            when 'private' then coalesce(owner = viewer, false)
+            when 'followers' then coalesce(owner = viewer, false)
+                or exists(select 1 from follows where user_id = viewer and following_id = owner)
+            else true
+        end
+$$ language sql stable;
+
+-- collection_cover returns photo of collection's cover work,
+-- or the first work in collection when cover not set,
+-- works hidden from viewer are skipped
+create function collection_cover(c collections, viewer uuid) returns varchar as $$
+    select coalesce(
+        (
+            select w.photo
+            from works w
+            where w.id = c.cover_work_id
+              and can_view_work(w.user_id, w.visibility, w.status, viewer)
+        ),
+        (
+            select w.photo
+            from collection_works cw
+                join works w on cw.work_id = w.id
+            where cw.collection_id = c.id
+              and can_view_work(w.user_id, w.visibility, w.status, viewer)
+            order by cw.position
+            limit 1
+        ),
+        ''
+    )
+$$ language sql stable;
+
+-- can_view_comment returns true if viewer can see the comment,
+-- hidden comments are visible only to its author and work owner
+create function can_view_comment(c comments, work_owner uuid, viewer uuid) returns bool as $$
+    select not is_blocked(c.user_id, viewer)
+        and not is_muted(viewer, c.user_id)
+        and (c.hidden_at is null or coalesce(viewer in (c.user_id, work_owner), false))
+$$ language sql stable;
+
+-- work_reactions returns reaction counts of the work as json object
+create function work_reactions(id bigint) returns json as $$
+    select coalesce(json_object_agg(reaction, cnt), '{}')
+    from (
+        select reaction, count(*) as cnt
+        from reactions
+        where work_id = id
+        group by reaction
+    ) t
+$$ language sql stable;
+
+-- comment_reactions returns reaction counts of the comment as json object
+create function comment_reactions(id uuid) returns json as $$
+    select coalesce(json_object_agg(reaction, cnt), '{}')
+    from (
+        select reaction, count(*) as cnt
+        from reactions
+        where comment_id = id
+        group by reaction
+    ) t
+$$ language sql stable;
+
+-- work_mentions returns mentions in the work's detail as json array
+create function work_mentions(target bigint) returns json as $$
+    select coalesce(json_agg(json_build_object(
+        'offset', m.position,
+        'length', m.length,
+        'username', u.username
+    ) order by m.position), '[]')
+    from mentions m
+        join users u on m.user_id = u.id
+    where m.work_id = target
+$$ language sql stable;
+
+-- comment_mentions returns mentions in the comment's content as json array
+create function comment_mentions(target uuid) returns json as $$
+    select coalesce(json_agg(json_build_object(
+        'offset', m.position,
+        'length', m.length,
+        'username', u.username
+    ) order by m.position), '[]')
+    from mentions m
+        join users u on m.user_id = u.id
+    where m.comment_id = target
+$$ language sql stable;
+
+-- works.favorite_count and works.comment_count are maintained by triggers,
+-- run "pikkanode repair-counts" to recompute them
+
+create function favorites_count() returns trigger as $$
+begin
+    if tg_op = 'INSERT' then
+        update works set favorite_count = favorite_count + 1 where id = new.work_id;
+    elsif tg_op = 'DELETE' then
+        update works set favorite_count = favorite_count - 1 where id = old.work_id;
+    end if;
+    return null;
+end
+$$ language plpgsql;
+
+create trigger favorites_count
+    after insert or delete on favorites
+    for each row execute procedure favorites_count();
+
+-- deleted comments (placeholders) are not counted
+create function comments_count() returns trigger as $$
+begin
+    if tg_op = 'INSERT' and new.deleted_at is null then
+        update works set comment_count = comment_count + 1 where id = new.work_id;
+    elsif tg_op = 'DELETE' and old.deleted_at is null then
+        update works set comment_count = comment_count - 1 where id = old.work_id;
+    elsif tg_op = 'UPDATE' and old.deleted_at is null and new.deleted_at is not null then
+        update works set comment_count = comment_count - 1 where id = new.work_id;
+    end if;
+    return null;
+end
+$$ language plpgsql;
+
+create trigger comments_count
+    after insert or delete or update of deleted_at on comments
+    for each row execute procedure comments_count();